@@ -1,12 +1,78 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/jnschaeffer/scheme/lang"
 )
 
+var expr = flag.String("e", "", "evaluate `expression` and print its value")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-e expression | file | -] [args...]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func run(i *lang.Interpreter, args []string) error {
+	switch {
+	case *expr != "":
+		v, err := i.EvalString(*expr)
+		if err != nil {
+			return err
+		}
+
+		if !v.IsUnspecified() {
+			fmt.Println(v)
+		}
+
+		return nil
+	case len(args) == 0:
+		return i.REPL(os.Stdin, os.Stdout)
+	case args[0] == "-":
+		return i.Load(os.Stdin)
+	default:
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return i.Load(f)
+	}
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
-	lang.REPL()
+
+	args := flag.Args()
+
+	cmdLine := append([]string{os.Args[0]}, args...)
+	if *expr == "" && len(args) > 0 {
+		cmdLine = args
+	}
+
+	i, err := lang.NewInterpreter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+
+	i.Define("command-line", func(...lang.Value) (lang.Value, error) {
+		return lang.FromGo(cmdLine)
+	}, 0, false)
+
+	err = run(i, args)
+
+	var exit *lang.ExitError
+	switch {
+	case errors.As(err, &exit):
+		os.Exit(exit.Code)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,289 @@
+package lang
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+/* BYTEVECTORS */
+
+func intArg(o *object) (int, error) {
+	return Value{o: o}.AsInt()
+}
+
+func byteArg(o *object) (byte, error) {
+	n, err := intArg(o)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 || n > 255 {
+		return 0, fmt.Errorf("byte out of range: %d", n)
+	}
+
+	return byte(n), nil
+}
+
+func bytevectorArg(o *object) ([]byte, error) {
+	if !isBytevector(o) {
+		return nil, typeMismatch(bvecT, o.t)
+	}
+
+	return o.v.([]byte), nil
+}
+
+// rangeArgs returns the optional start and end arguments of a procedure
+// working on a sequence of length n.
+func rangeArgs(args []*object, n int) (int, int, error) {
+	start, end := 0, n
+
+	var err error
+
+	if len(args) > 0 {
+		if start, err = intArg(args[0]); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if len(args) > 1 {
+		if end, err = intArg(args[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if start < 0 || end > n || start > end {
+		return 0, 0, fmt.Errorf("invalid range [%d, %d) for length %d", start, end, n)
+	}
+
+	return start, end, nil
+}
+
+func bytevectorObj(b []byte) *object {
+	return &object{
+		t: bvecT,
+		v: b,
+	}
+}
+
+func objsToBytes(objs []*object) ([]byte, error) {
+	b := make([]byte, len(objs))
+	for i, o := range objs {
+		x, err := byteArg(o)
+		if err != nil {
+			return nil, err
+		}
+
+		b[i] = x
+	}
+
+	return b, nil
+}
+
+func bytevector(args ...*object) (*object, error) {
+	b, err := objsToBytes(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytevectorObj(b), nil
+}
+
+// The procedures that allocate bytevectors of arbitrary length charge them
+// against the limits in st.
+
+func makeBytevectorGen(st *evalState) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		k, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if k < 0 {
+			return nil, fmt.Errorf("negative length %d", k)
+		}
+
+		var fill byte
+		if len(args) == 2 {
+			if fill, err = byteArg(args[1]); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := st.allocBytes(k); err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, k)
+		for i := range b {
+			b[i] = fill
+		}
+
+		return bytevectorObj(b), nil
+	}
+}
+
+func bytevectorLength(args ...*object) (*object, error) {
+	b, err := bytevectorArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return numObj(number{t: intT, intVal: len(b)}), nil
+}
+
+func bytevectorIndex(bv, k *object) ([]byte, int, error) {
+	b, err := bytevectorArg(bv)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	i, err := intArg(k)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if i < 0 || i >= len(b) {
+		return nil, 0, fmt.Errorf("index %d out of range for length %d", i, len(b))
+	}
+
+	return b, i, nil
+}
+
+func bytevectorU8Ref(args ...*object) (*object, error) {
+	b, i, err := bytevectorIndex(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return numObj(number{t: intT, intVal: int(b[i])}), nil
+}
+
+func bytevectorU8Set(args ...*object) (*object, error) {
+	b, i, err := bytevectorIndex(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := byteArg(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	b[i] = x
+
+	return nil, nil
+}
+
+func bytevectorCopyGen(st *evalState) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		b, err := bytevectorArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		start, end, err := rangeArgs(args[1:], len(b))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := st.allocBytes(end - start); err != nil {
+			return nil, err
+		}
+
+		return bytevectorObj(append([]byte{}, b[start:end]...)), nil
+	}
+}
+
+func bytevectorCopyTo(args ...*object) (*object, error) {
+	to, err := bytevectorArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := bytevectorArg(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, err := rangeArgs(args[3:], len(from))
+	if err != nil {
+		return nil, err
+	}
+
+	if i < 0 || i+end-start > len(to) {
+		return nil, fmt.Errorf("not enough room to copy %d bytes at %d", end-start, i)
+	}
+
+	copy(to[i:], from[start:end])
+
+	return nil, nil
+}
+
+func bytevectorAppendGen(st *evalState) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		n := 0
+		for _, a := range args {
+			b, err := bytevectorArg(a)
+			if err != nil {
+				return nil, err
+			}
+
+			n += len(b)
+		}
+
+		if err := st.allocBytes(n); err != nil {
+			return nil, err
+		}
+
+		ret := make([]byte, 0, n)
+		for _, a := range args {
+			ret = append(ret, a.v.([]byte)...)
+		}
+
+		return bytevectorObj(ret), nil
+	}
+}
+
+func utf8ToString(args ...*object) (*object, error) {
+	b, err := bytevectorArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, err := rangeArgs(args[1:], len(b))
+	if err != nil {
+		return nil, err
+	}
+
+	if !utf8.Valid(b[start:end]) {
+		return nil, fmt.Errorf("invalid UTF-8")
+	}
+
+	ret := &object{
+		t: strT,
+		v: string(b[start:end]),
+	}
+
+	return ret, nil
+}
+
+func stringToUTF8(args ...*object) (*object, error) {
+	s := args[0]
+	if !isString(s) {
+		return nil, typeMismatch(strT, s.t)
+	}
+
+	runes := []rune(s.v.(string))
+
+	start, end, err := rangeArgs(args[1:], len(runes))
+	if err != nil {
+		return nil, err
+	}
+
+	return bytevectorObj([]byte(string(runes[start:end]))), nil
+}
@@ -0,0 +1,52 @@
+package lang
+
+import "testing"
+
+func TestBytevectors(t *testing.T) {
+	testEval(t, []evalTest{
+		{src: "#u8(1 2 255)", want: "#u8(1 2 255)"},
+		{src: "#u8()", want: "#u8()"},
+		{src: "(bytevector? #u8(1))", want: "#t"},
+		{src: "(bytevector? '(1))", want: "#f"},
+		{src: "(bytevector 1 2)", want: "#u8(1 2)"},
+		{src: "(make-bytevector 3)", want: "#u8(0 0 0)"},
+		{src: "(make-bytevector 2 7)", want: "#u8(7 7)"},
+		{src: "(bytevector-length #u8(1 2 3))", want: "3"},
+		{src: "(bytevector-u8-ref #u8(5 6) 1)", want: "6"},
+		{src: "(define b (bytevector 1 2)) (bytevector-u8-set! b 0 9) b", want: "#u8(9 2)"},
+		{src: "(bytevector-copy #u8(1 2 3 4) 1)", want: "#u8(2 3 4)"},
+		{src: "(bytevector-copy #u8(1 2 3 4) 1 3)", want: "#u8(2 3)"},
+		{src: "(define b (bytevector 1 2)) (define c (bytevector-copy b)) (bytevector-u8-set! c 0 9) b", want: "#u8(1 2)"},
+		{src: "(define b (make-bytevector 4 0)) (bytevector-copy! b 1 #u8(7 8 9) 1) b", want: "#u8(0 8 9 0)"},
+		{src: "(define b (bytevector 1 2 3 4)) (bytevector-copy! b 1 b 0 2) b", want: "#u8(1 1 2 4)"},
+		{src: "(bytevector-append #u8(1) #u8() #u8(2 3))", want: "#u8(1 2 3)"},
+		{src: "(bytevector-append)", want: "#u8()"},
+		{src: `(utf8->string #u8(206 187 120))`, want: `"λx"`},
+		{src: `(utf8->string #u8(97 98 99) 1 2)`, want: `"b"`},
+		{src: `(string->utf8 "λx")`, want: "#u8(206 187 120)"},
+		{src: `(string->utf8 "abc" 1)`, want: "#u8(98 99)"},
+		{src: "#u8(256)", err: "1:1: byte out of range: 256"},
+		{src: "(bytevector -1)", err: "1:1: byte out of range: -1"},
+		{src: "(bytevector-u8-ref #u8(1) 1)", err: "1:1: index 1 out of range for length 1"},
+		{src: "(bytevector-u8-set! #u8(1) 0 300)", err: "1:1: byte out of range: 300"},
+		{src: "(bytevector-copy #u8(1 2) 2 1)", err: "1:1: invalid range [2, 1) for length 2"},
+		{src: "(make-bytevector -1)", err: "1:1: negative length -1"},
+		{src: "(utf8->string #u8(255))", err: "1:1: invalid UTF-8"},
+	})
+}
+
+func TestEq(t *testing.T) {
+	testEval(t, []evalTest{
+		{src: "(eq? 'a 'a)", want: "#t"},
+		{src: "(eq? '() '())", want: "#t"},
+		{src: `(eq? #\a #\a)`, want: "#t"},
+		{src: "(eq? car car)", want: "#t"},
+		{src: "(eq? car cdr)", want: "#f"},
+		{src: "(eq? map map)", want: "#t"},
+		{src: "(eq? map not)", want: "#f"},
+		{src: "(eq? '(1) '(1))", want: "#f"},
+		{src: "(define b #u8(1)) (eq? b b)", want: "#t"},
+		{src: "(eq? #u8(1) #u8(1))", want: "#f"},
+		{src: "(eq? '#(1) '#(1))", want: "#f"},
+	})
+}
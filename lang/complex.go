@@ -0,0 +1,330 @@
+package lang
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strings"
+)
+
+/* COMPLEX NUMBERS */
+
+// complexNum returns c as a number, demoting it to a real if its imaginary
+// part is zero.
+func complexNum(c complex128) number {
+	if imag(c) == 0 {
+		return realNum(real(c))
+	}
+
+	return number{
+		t:          complexT,
+		complexVal: c,
+	}
+}
+
+func (n number) complex() complex128 {
+	if n.t == complexT {
+		return n.complexVal
+	}
+
+	return complex(n.float(), 0)
+}
+
+// parseComplex parses a complex number without prefixes, either in
+// rectangular form, such as 1+2i or -i, or in polar form, such as 1@3.14.
+func parseComplex(s string, radix int) (complex128, bool) {
+	if i := strings.IndexByte(s, '@'); i >= 0 {
+		mag, ok1 := parseFloat(s[:i], radix)
+		ang, ok2 := parseFloat(s[i+1:], radix)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+
+		return cmplx.Rect(mag, ang), true
+	}
+
+	if !strings.HasSuffix(s, "i") && !strings.HasSuffix(s, "I") {
+		return 0, false
+	}
+
+	s = s[:len(s)-1]
+
+	// the imaginary part begins at the last sign not in an exponent
+	i := strings.LastIndexAny(s, "+-")
+	for i > 0 && radix == 10 && (s[i-1] == 'e' || s[i-1] == 'E') {
+		i = strings.LastIndexAny(s[:i-1], "+-")
+	}
+
+	if i < 0 {
+		return 0, false
+	}
+
+	re, im := 0.0, 0.0
+	ok := true
+
+	if i > 0 {
+		if re, ok = parseFloat(s[:i], radix); !ok {
+			return 0, false
+		}
+	}
+
+	switch s[i:] {
+	case "+":
+		im = 1
+	case "-":
+		im = -1
+	default:
+		im, ok = parseFloat(s[i:], radix)
+	}
+
+	return complex(re, im), ok
+}
+
+// parseFloat parses a real number without prefixes as an inexact number.
+func parseFloat(s string, radix int) (float64, bool) {
+	exact, inexact, ok := parseReal(s, radix)
+	if ok && exact != nil {
+		inexact, _ = exact.Float64()
+	}
+
+	return inexact, ok
+}
+
+func makeRectangular(args ...*object) (*object, error) {
+	x, err := realArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := realArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if y.isExact() && y.sign() == 0 {
+		return args[0], nil
+	}
+
+	return numObj(complexNum(complex(x.float(), y.float()))), nil
+}
+
+func makePolar(args ...*object) (*object, error) {
+	mag, err := realArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ang, err := realArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if ang.isExact() && ang.sign() == 0 {
+		return args[0], nil
+	}
+
+	return numObj(complexNum(cmplx.Rect(mag.float(), ang.float()))), nil
+}
+
+func realPart(args ...*object) (*object, error) {
+	n, err := numArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if n.t != complexT {
+		return args[0], nil
+	}
+
+	return numObj(realNum(real(n.complexVal))), nil
+}
+
+func imagPart(args ...*object) (*object, error) {
+	n, err := numArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if n.t != complexT {
+		return numObj(intNum(0)), nil
+	}
+
+	return numObj(realNum(imag(n.complexVal))), nil
+}
+
+func magnitude(args ...*object) (*object, error) {
+	n, err := numArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case n.t == complexT:
+		return numObj(realNum(cmplx.Abs(n.complexVal))), nil
+	case n.sign() < 0:
+		m, err := sub(intNum(0).as(n.t), n)
+		return numObj(m), err
+	}
+
+	return args[0], nil
+}
+
+func angle(args ...*object) (*object, error) {
+	n, err := numArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case n.t == complexT:
+		return numObj(realNum(cmplx.Phase(n.complexVal))), nil
+	case n.isExact() && n.sign() >= 0:
+		return numObj(intNum(0)), nil
+	}
+
+	return numObj(realNum(math.Atan2(0, n.float()))), nil
+}
+
+/* TRANSCENDENTAL FUNCTIONS */
+
+// transcendental applies fr to n if n is real and inDomain, which may be nil,
+// holds for it, and applies fc otherwise. The result is inexact.
+func transcendental(n number, fr func(float64) float64, fc func(complex128) complex128, inDomain func(float64) bool) number {
+	if n.t != complexT && (inDomain == nil || inDomain(n.float())) {
+		return realNum(fr(n.float()))
+	}
+
+	return complexNum(fc(n.complex()))
+}
+
+func transcendentalGen(fr func(float64) float64, fc func(complex128) complex128, inDomain func(float64) bool) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		n, err := numArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return numObj(transcendental(n, fr, fc, inDomain)), nil
+	}
+}
+
+// isNonNegative also holds for NaN, which real functions pass through.
+func isNonNegative(x float64) bool {
+	return !(x < 0)
+}
+
+func inUnitInterval(x float64) bool {
+	return !(x < -1 || x > 1)
+}
+
+// log returns the natural logarithm of its first argument, or its logarithm
+// in the base given by its second.
+func log(args ...*object) (*object, error) {
+	logs := make([]number, len(args))
+	for i, o := range args {
+		n, err := numArg(o)
+		if err != nil {
+			return nil, err
+		}
+
+		logs[i] = transcendental(n, math.Log, cmplx.Log, isNonNegative)
+	}
+
+	if len(logs) == 1 {
+		return numObj(logs[0]), nil
+	}
+
+	n, err := applyBinaryOp(div, logs[0], logs[1])
+
+	return numObj(n), err
+}
+
+// atan returns the arctangent of its argument, or with two real arguments y
+// and x, the angle of the point (x, y).
+func atan(args ...*object) (*object, error) {
+	if len(args) == 1 {
+		return transcendentalGen(math.Atan, cmplx.Atan, nil)(args...)
+	}
+
+	y, err := realArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := realArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return numObj(realNum(math.Atan2(y.float(), x.float()))), nil
+}
+
+// sqrt returns the principal square root of its argument, which is exact for
+// exact squares of rationals.
+func sqrt(args ...*object) (*object, error) {
+	n, err := numArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if n.isExact() && n.sign() >= 0 {
+		r := n.rat()
+		num, den := new(big.Int).Sqrt(r.Num()), new(big.Int).Sqrt(r.Denom())
+
+		if new(big.Int).Mul(num, num).Cmp(r.Num()) == 0 &&
+			new(big.Int).Mul(den, den).Cmp(r.Denom()) == 0 {
+			return numObj(ratNum(new(big.Rat).SetFrac(num, den))), nil
+		}
+	}
+
+	return numObj(transcendental(n, math.Sqrt, cmplx.Sqrt, isNonNegative)), nil
+}
+
+// maxExptBits bounds the size of an exact result of expt, which could
+// otherwise take longer to compute than any limit allows for.
+const maxExptBits = 1 << 20
+
+// expt raises its first argument to the power of its second. An exact number
+// raised to an exact integer power is exact.
+func expt(args ...*object) (*object, error) {
+	z1, err := numArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	z2, err := numArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case z1.isExact() && z2.t == bigT:
+		return nil, fmt.Errorf("exponent %s too large", z2)
+	case z1.isExact() && z2.t == intT:
+		r, k := z1.rat(), z2.intVal
+		if k < 0 {
+			if r.Sign() == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			r, k = new(big.Rat).Inv(r), -k
+		}
+
+		if b := max(r.Num().BitLen(), r.Denom().BitLen()); b > 1 && k > maxExptBits/b {
+			return nil, fmt.Errorf("result of expt too large")
+		}
+
+		e := big.NewInt(int64(k))
+		num := new(big.Int).Exp(r.Num(), e, nil)
+		den := new(big.Int).Exp(r.Denom(), e, nil)
+
+		return numObj(ratNum(new(big.Rat).SetFrac(num, den))), nil
+	case z1.t != complexT && z2.t != complexT:
+		x, y := z1.float(), z2.float()
+		if x >= 0 || y == math.Trunc(y) {
+			return numObj(realNum(math.Pow(x, y))), nil
+		}
+	}
+
+	return numObj(complexNum(cmplx.Pow(z1.complex(), z2.complex()))), nil
+}
@@ -1,11 +1,14 @@
 package lang
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"github.com/golang/glog"
-	"io"
-	"os"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strings"
+	"unicode"
 )
 
 type objType int
@@ -35,7 +38,10 @@ const (
 	qualifiedSymT
 
 	intT
+	bigT
+	ratT
 	realT
+	complexT
 )
 
 var typeMap = map[objType]string{
@@ -55,12 +61,56 @@ var typeMap = map[objType]string{
 	environmentT: "environment",
 	portT:        "port",
 	eofT:         "eof",
+	intT:         "integer",
+	bigT:         "integer",
+	ratT:         "rational",
+	realT:        "real",
+	complexT:     "complex",
 }
 
 func typeMismatch(exp, obs objType) error {
 	return fmt.Errorf("type mismatch: expected %s, got %s", exp, obs)
 }
 
+// posError is an error that occurred at a position in source text.
+type posError struct {
+	pos position
+	err error
+}
+
+func (e *posError) Error() string {
+	return fmt.Sprintf("%s: %s", e.pos, e.err)
+}
+
+func (e *posError) Unwrap() error {
+	return e.err
+}
+
+func posErrorf(pos position, format string, args ...interface{}) error {
+	return &posError{
+		pos: pos,
+		err: fmt.Errorf(format, args...),
+	}
+}
+
+// withPos attaches the source position of o to err, unless o was not read
+// from source or err already has a position.
+func withPos(err error, o *object) error {
+	if o == nil || o.pos == nil {
+		return err
+	}
+
+	var pe *posError
+	if errors.As(err, &pe) {
+		return err
+	}
+
+	return &posError{
+		pos: *o.pos,
+		err: err,
+	}
+}
+
 func isTypeGen(t objType) func(o *object) bool {
 	return func(o *object) bool {
 		return o != nil && o.t == t
@@ -85,6 +135,7 @@ var (
 	isNum         = isTypeGen(numT)
 	isVec         = isTypeGen(vecT)
 	isChar        = isTypeGen(charT)
+	isBytevector  = isTypeGen(bvecT)
 	isString      = isTypeGen(strT)
 	isSymbol      = isTypeGen(symbolT)
 	isList        = isTypeGen(listT)
@@ -100,6 +151,7 @@ var (
 type env struct {
 	m     map[string]*object
 	outer *env
+	st    *evalState
 }
 
 func (e *env) lookup(k string) (*object, bool) {
@@ -133,29 +185,6 @@ type list struct {
 	cdr *object
 }
 
-func (l *list) String() string {
-
-	str := fmt.Sprintf("(%s", l.car.String())
-	x := l.cdr
-	for {
-		if x.t == listT {
-			if x.v == nil {
-				str += fmt.Sprintf(")")
-				break
-			} else {
-				o := x.v.(*list)
-				str += fmt.Sprintf(" %s", o.car.String())
-				x = o.cdr
-			}
-		} else {
-			str += fmt.Sprintf(" . %s)", x.String())
-			break
-		}
-	}
-
-	return str
-}
-
 func vecToList(objs []*object) *object {
 	l := len(objs)
 	o := emptyList
@@ -202,6 +231,9 @@ func listToVec(o *object) []*object {
 type object struct {
 	t objType
 	v interface{}
+
+	// pos is where the object was read from, if it was read from source.
+	pos *position
 }
 
 func symbolObj(s string) *object {
@@ -218,6 +250,62 @@ func boolObj(b bool) *object {
 	}
 }
 
+func numObj(n number) *object {
+	return &object{
+		t: numT,
+		v: n,
+	}
+}
+
+// escape returns s between delim characters, escaping delim, backslashes
+// and control characters as in a string literal.
+func escape(s string, delim rune) string {
+	var b strings.Builder
+
+	b.WriteRune(delim)
+
+	for _, r := range s {
+		switch {
+		case r == delim || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '\a':
+			b.WriteString(`\a`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&b, `\x%x;`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	b.WriteRune(delim)
+
+	return b.String()
+}
+
+// writeChar returns the #\ syntax for r, using a name where R7RS has one.
+func writeChar(r rune) string {
+	for name, c := range charNames {
+		if c == r && name != "nul" {
+			return `#\` + name
+		}
+	}
+
+	if unicode.IsControl(r) || unicode.IsSpace(r) {
+		return fmt.Sprintf(`#\x%x`, r)
+	}
+
+	return `#\` + string(r)
+}
+
 func (o *object) String() string {
 	if o == nil {
 		return ""
@@ -235,14 +323,26 @@ func (o *object) String() string {
 	case listT:
 		if o.v == nil {
 			return "()"
-		} else {
-			lst := o.v.(*list)
-			return lst.String()
 		}
+		return writeString(o, findLabels(o, false))
+	case vecT:
+		return writeString(o, findLabels(o, false))
 	case symbolT:
+		if s := o.v.(string); !isPlainIdentifier(s) {
+			return escape(s, '|')
+		}
 		return o.v.(string)
 	case strT:
-		return fmt.Sprintf("\"%s\"", o.v.(string))
+		return escape(o.v.(string), '"')
+	case charT:
+		return writeChar(o.v.(rune))
+	case bvecT:
+		b := o.v.([]byte)
+		strs := make([]string, len(b))
+		for i, x := range b {
+			strs[i] = fmt.Sprintf("%d", x)
+		}
+		return fmt.Sprintf("#u8(%s)", strings.Join(strs, " "))
 	case procT:
 		return fmt.Sprintf("#<proc>")
 	case macroT:
@@ -337,12 +437,15 @@ func isApplication(o *object) bool {
 
 /* EXPANSION */
 
-func applyMacro(m *object, argv []*object, e *env) (*object, error) {
+// applyMacro runs the transformer of the macro m on the unevaluated
+// arguments argv. Like any procedure, the transformer runs in the environment
+// it was defined in, not the one it is used in.
+func applyMacro(m *object, argv []*object) (*object, error) {
 	glog.V(3).Infof("applying %s", m.v)
 	p := m.v.(compoundProc)
 	expr := p.body[0]
 
-	f, err := extendEnv(p.params, argv, p.hasTail, e)
+	f, err := extendEnv(p.params, argv, p.hasTail, p.e)
 	if err != nil {
 		return nil, err
 	}
@@ -350,6 +453,42 @@ func applyMacro(m *object, argv []*object, e *env) (*object, error) {
 	return eval(expr, f)
 }
 
+// checkAcyclic returns an error if the code o contains itself outside of
+// quoted data. Datum labels can build such code, which expand and eval would
+// never finish walking.
+func checkAcyclic(o *object) error {
+	active := map[*object]bool{}
+	done := map[*object]bool{}
+
+	var walk func(o *object) error
+	walk = func(o *object) error {
+		if !isList(o) || isEmptyList(o) || isQuoted(o) || done[o] {
+			return nil
+		}
+
+		if active[o] {
+			return withPos(fmt.Errorf("cyclic expression %s", o), o)
+		}
+
+		active[o] = true
+
+		l := o.v.(*list)
+		if err := walk(l.car); err != nil {
+			return err
+		}
+		if err := walk(l.cdr); err != nil {
+			return err
+		}
+
+		delete(active, o)
+		done[o] = true
+
+		return nil
+	}
+
+	return walk(o)
+}
+
 func expand(o *object, e *env) (*object, error) {
 	if !(isList(o) && !isEmptyList(o)) {
 		return o, nil
@@ -369,18 +508,25 @@ func expand(o *object, e *env) (*object, error) {
 		m, ok := e.lookup(head.v.(string))
 
 		if ok && m != nil && isMacro(m) {
-			glog.V(3).Infof("found macro %s", head.String())
+			// a macro can expand into another use of itself, so each
+			// expansion nests one level deeper
+			if err := e.st.enter(); err != nil {
+				return nil, withPos(err, o)
+			}
+			defer e.st.leave()
+
+			glog.V(3).Infof("found macro %s", head)
 			argv := listToVec(tail)
-			glog.V(3).Infof("expanding %s", o.String())
+			glog.V(3).Infof("expanding %s", o)
 
-			r, err := applyMacro(m, argv, e)
+			r, err := applyMacro(m, argv)
 
 			if err != nil {
 				glog.V(3).Infof("MACRO ERROR")
-				return nil, err
+				return nil, withPos(err, o)
 			}
 
-			glog.V(3).Infof("expanded to %s", r.String())
+			glog.V(3).Infof("expanded to %s", r)
 
 			return expand(r, e)
 		}
@@ -439,6 +585,10 @@ func extendEnv(params []string, vals []*object, hasTail bool, e *env) (*env, err
 	}
 
 	if hasTail {
+		if err := e.st.alloc(len(tail)); err != nil {
+			return nil, err
+		}
+
 		boundVals = append(boundVals, vecToList(tail))
 	}
 
@@ -455,6 +605,7 @@ func extendEnv(params []string, vals []*object, hasTail bool, e *env) (*env, err
 	ret := &env{
 		m:     m,
 		outer: e,
+		st:    e.st,
 	}
 
 	return ret, nil
@@ -517,8 +668,7 @@ func evalAssignment(o *object, e *env) (*object, error) {
 }
 
 func evalPrimitive(p primitiveProc, args []*object, e *env) (*object, error) {
-	if !p.hasTail && p.nArgs != len(args) {
-		err := fmt.Errorf("argument length mismatch: %d != %d", p.nArgs, len(args))
+	if err := p.checkArity(len(args)); err != nil {
 		return nil, err
 	}
 
@@ -629,7 +779,7 @@ func evalQuasiquote(o *object, e *env, level int) (*object, error) {
 		}
 		result := cons(symbolObj("quasiquote"), cons(p, emptyList))
 
-		glog.V(3).Infof("returning %s", q.String())
+		glog.V(3).Infof("returning %s", q)
 		return result, nil
 
 	case isUnquoted(q):
@@ -771,10 +921,25 @@ func evalLambda(o *object, e *env) (*object, error) {
 	return ret, nil
 }
 
-func eval(o *object, e *env) (*object, error) {
+func eval(o *object, e *env) (ret *object, err error) {
+	if err := e.st.enter(); err != nil {
+		return nil, withPos(err, o)
+	}
+	defer e.st.leave()
 
-	glog.V(4).Infof("evaluating %s", o.String())
+	defer func() {
+		if err != nil {
+			err = withPos(err, o)
+		}
+	}()
+
+	glog.V(4).Infof("evaluating %s", o)
 Tailcall:
+	// every procedure call and tail call passes through here
+	if err := e.st.step(); err != nil {
+		return nil, err
+	}
+
 	switch {
 	case o == nil:
 		return nil, nil
@@ -868,115 +1033,119 @@ Tailcall:
 	return nil, fmt.Errorf("unknown statement %s", o)
 }
 
-var globalEnvMap = map[string]*object{
-	"cons":            procGen(consPrimitive, 2, false),
-	"car":             procGen(car, 1, false),
-	"cdr":             procGen(cdr, 1, false),
-	"eq?":             procGen(eq, 2, false),
-	"quit":            procGen(quit, 0, false),
-	"exit":            procGen(quit, 0, false),
-	"+":               procGen(binaryOpGen(add, parseNum("0"), false), 0, true),
-	"-":               procGen(binaryOpGen(sub, parseNum("0"), true), 0, true),
-	"*":               procGen(binaryOpGen(mul, parseNum("1"), false), 0, true),
-	"/":               procGen(binaryOpGen(div, parseNum("1.0"), true), 0, true),
-	"read":            procGen(read, 0, true),
-	"write":           procGen(write, 1, false),
-	"eval":            procGen(evalProc, 2, false),
-	"symbol?":         procGen(isTypeProcGen(isSymbol), 1, false),
-	"pair?":           procGen(isTypeProcGen(isList), 1, false),
-	"string?":         procGen(isTypeProcGen(isList), 1, false),
-	"symbol->string":  procGen(symbolToString, 1, false),
-	"open-input-file": procGen(openInputFile, 1, false),
-	"close-port":      procGen(closePort, 1, false),
-	"eof-object":      procGen(eofObject, 0, false),
-	"eof-object?":     procGen(isTypeProcGen(isEOF), 1, false),
-}
-
-func init() {
-	globalEnvMap["null-environment"] = procGen(nullEnv, 1, false)
-}
-
-func collectInput(r *bufio.Reader, prompt string, writePrompt bool) (string, error) {
-	var stmt []byte
-
-	leftCnt := 0
-	rightCnt := 0
-
-	for {
-
-		if writePrompt {
-			if _, err := os.Stdout.WriteString(prompt); err != nil {
-				return "", err
-			}
-		}
-		line, err := r.ReadBytes('\n')
-		if err == io.EOF {
-			return string(append(stmt, line...)), err
-		}
-
-		for _, b := range line {
-			if b == '(' {
-				leftCnt++
-			}
-
-			if b == ')' {
-				rightCnt++
-			}
-		}
-
-		if leftCnt < rightCnt {
-			return "", fmt.Errorf("mismatched parentheses")
-		}
-
-		stmt = append(stmt, line...)
-
-		if leftCnt == rightCnt {
-			return string(stmt), nil
-		}
+// newGlobalEnv returns a global environment holding a fresh set of primitive
+// bindings, so that definitions made in one environment never leak into
+// another.
+func newGlobalEnv(st *evalState) *env {
+	m := map[string]*object{
+		"cons":               procGen(consGen(st), 2, false),
+		"car":                procGen(car, 1, false),
+		"cdr":                procGen(cdr, 1, false),
+		"eq?":                procGen(eq, 2, false),
+		"quit":               optProcGen(quit, 0, 1, false),
+		"exit":               optProcGen(quit, 0, 1, false),
+		"+":                  procGen(binaryOpGen(add, parseNum("0"), false), 0, true),
+		"-":                  procGen(binaryOpGen(sub, parseNum("0"), true), 0, true),
+		"*":                  procGen(binaryOpGen(mul, parseNum("1"), false), 0, true),
+		"/":                  procGen(binaryOpGen(div, parseNum("1"), true), 0, true),
+		"=":                  procGen(compareGen(numEq, false), 1, true),
+		"<":                  procGen(compareGen(numLt, true), 1, true),
+		">":                  procGen(compareGen(numGt, true), 1, true),
+		"<=":                 procGen(compareGen(numLe, true), 1, true),
+		">=":                 procGen(compareGen(numGe, true), 1, true),
+		"max":                procGen(extremumGen(numGt), 1, true),
+		"min":                procGen(extremumGen(numLt), 1, true),
+		"number?":            procGen(isTypeProcGen(isNum), 1, false),
+		"zero?":              procGen(numPredGen(isZero), 1, false),
+		"positive?":          procGen(realPredGen(isPositive), 1, false),
+		"negative?":          procGen(realPredGen(isNegative), 1, false),
+		"odd?":               procGen(isOdd, 1, false),
+		"even?":              procGen(isEven, 1, false),
+		"numerator":          procGen(ratPartGen((*big.Rat).Num), 1, false),
+		"denominator":        procGen(ratPartGen((*big.Rat).Denom), 1, false),
+		"rationalize":        procGen(rationalize, 2, false),
+		"exact?":             procGen(numPredGen(number.isExact), 1, false),
+		"inexact?":           procGen(numPredGen(isInexact), 1, false),
+		"exact-integer?":     procGen(numPredGen(isExactInteger), 1, false),
+		"nan?":               procGen(numPredGen(isNaN), 1, false),
+		"infinite?":          procGen(numPredGen(isInfinite), 1, false),
+		"finite?":            procGen(numPredGen(isFinite), 1, false),
+		"exact":              procGen(exact, 1, false),
+		"inexact":            procGen(inexact, 1, false),
+		"make-rectangular":   procGen(makeRectangular, 2, false),
+		"make-polar":         procGen(makePolar, 2, false),
+		"real-part":          procGen(realPart, 1, false),
+		"imag-part":          procGen(imagPart, 1, false),
+		"magnitude":          procGen(magnitude, 1, false),
+		"angle":              procGen(angle, 1, false),
+		"exp":                procGen(transcendentalGen(math.Exp, cmplx.Exp, nil), 1, false),
+		"log":                optProcGen(log, 1, 1, false),
+		"sin":                procGen(transcendentalGen(math.Sin, cmplx.Sin, nil), 1, false),
+		"cos":                procGen(transcendentalGen(math.Cos, cmplx.Cos, nil), 1, false),
+		"tan":                procGen(transcendentalGen(math.Tan, cmplx.Tan, nil), 1, false),
+		"asin":               procGen(transcendentalGen(math.Asin, cmplx.Asin, inUnitInterval), 1, false),
+		"acos":               procGen(transcendentalGen(math.Acos, cmplx.Acos, inUnitInterval), 1, false),
+		"atan":               optProcGen(atan, 1, 1, false),
+		"sqrt":               procGen(sqrt, 1, false),
+		"expt":               procGen(expt, 2, false),
+		"read":               optProcGen(read, 0, 1, false),
+		"write":              procGen(writeGen(st), 1, false),
+		"write-shared":       procGen(writeSharedGen(st), 1, false),
+		"write-simple":       procGen(writeSimpleGen(st), 1, false),
+		"eval":               procGen(evalProc, 2, false),
+		"symbol?":            procGen(isTypeProcGen(isSymbol), 1, false),
+		"pair?":              procGen(isTypeProcGen(isList), 1, false),
+		"string?":            procGen(isTypeProcGen(isList), 1, false),
+		"symbol->string":     procGen(symbolToString, 1, false),
+		"string->number":     optProcGen(stringToNumber, 1, 1, false),
+		"open-input-file":    procGen(openInputFile, 1, false),
+		"close-port":         procGen(closePort, 1, false),
+		"eof-object":         procGen(eofObject, 0, false),
+		"eof-object?":        procGen(isTypeProcGen(isEOF), 1, false),
+		"char?":              procGen(isTypeProcGen(isChar), 1, false),
+		"char->integer":      procGen(charToInteger, 1, false),
+		"integer->char":      procGen(integerToChar, 1, false),
+		"bytevector?":        procGen(isTypeProcGen(isBytevector), 1, false),
+		"bytevector":         procGen(bytevector, 0, true),
+		"make-bytevector":    optProcGen(makeBytevectorGen(st), 1, 1, false),
+		"bytevector-length":  procGen(bytevectorLength, 1, false),
+		"bytevector-u8-ref":  procGen(bytevectorU8Ref, 2, false),
+		"bytevector-u8-set!": procGen(bytevectorU8Set, 3, false),
+		"bytevector-copy":    optProcGen(bytevectorCopyGen(st), 1, 2, false),
+		"bytevector-copy!":   optProcGen(bytevectorCopyTo, 3, 2, false),
+		"bytevector-append":  procGen(bytevectorAppendGen(st), 0, true),
+		"utf8->string":       optProcGen(utf8ToString, 1, 2, false),
+		"string->utf8":       optProcGen(stringToUTF8, 1, 2, false),
+		"null-environment":   procGen(nullEnvGen(st), 1, false),
 	}
-}
 
-func REPL() {
-	input := bufio.NewReader(os.Stdin)
-	outer := &env{
-		m:     globalEnvMap,
+	nameProcs(m)
+
+	return &env{
+		m:     m,
 		outer: nil,
+		st:    st,
 	}
+}
 
-	e := &env{
-		m:     map[string]*object{},
-		outer: outer,
+// restrictEnv returns a copy of e holding only the bindings named in allowed,
+// or e itself if allowed is nil. Procedures already bound in e keep seeing
+// every binding of e.
+func restrictEnv(e *env, allowed map[string]bool) *env {
+	if allowed == nil {
+		return e
 	}
 
-	for {
-		line, err := collectInput(input, "] ", true)
-		if err == io.EOF {
-			return
-		}
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-			continue
-		}
-
-		p, err := parse(line)
-		if err != nil {
-			fmt.Printf("PARSE: %s\n", err)
-			continue
-		}
-
-		p, err = expand(p, e)
-		if err != nil {
-			fmt.Printf("EXPAND: %s\n", err)
-			continue
+	m := map[string]*object{}
+	for k, o := range e.m {
+		if allowed[k] {
+			m[k] = o
 		}
+	}
 
-		o, err := eval(p, e)
-		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
-		} else {
-			if o != nil {
-				fmt.Printf("%s\n", o)
-			}
-		}
+	return &env{
+		m:     m,
+		outer: e.outer,
+		st:    e.st,
 	}
 }
@@ -0,0 +1,353 @@
+package lang
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/* FUNC */
+
+// Func is a Go function that can be called from Scheme. Returning the zero
+// Value leaves the result unspecified.
+type Func func(args ...Value) (Value, error)
+
+// Arity describes the arguments a Func accepts: Required arguments, followed
+// by up to Optional further arguments, followed by any number of arguments
+// if Variadic is set. Calls that do not match are rejected before the Func
+// runs.
+type Arity struct {
+	Required int
+	Optional int
+	Variadic bool
+}
+
+func (f Func) primitive() primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		vals := make([]Value, len(args))
+		for i, a := range args {
+			vals[i] = Value{o: a}
+		}
+
+		v, err := f(vals...)
+		if err != nil {
+			return nil, err
+		}
+
+		return v.o, nil
+	}
+}
+
+/* INTERPRETER */
+
+// Interpreter evaluates Scheme code in its own global environment. An
+// Interpreter must not be used from more than one goroutine at a time.
+type Interpreter struct {
+	e       *env
+	st      *evalState
+	prelude string
+}
+
+// Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// WithLimits sets the resource limits applied to each evaluation.
+func WithLimits(l Limits) Option {
+	return func(i *Interpreter) {
+		i.st.limits = l
+	}
+}
+
+// SafePrimitives is an allow-list of primitives and prelude definitions that
+// cannot touch the filesystem or standard input, end the process or evaluate
+// arbitrary code. Output from write and its variants goes to the writer set
+// with WithOutput.
+var SafePrimitives = []string{
+	"cons", "car", "cdr", "eq?",
+	"map", "not", "let", "let*", "letrec*", "or", "and", "null?", "list?",
+	"make-list", "list", "reverse",
+	"cadr", "cdar", "caar", "cddr", "caaar", "caadr", "cddar", "cdddr",
+	"caaaar", "caaadr", "cdddar", "cddddr",
+	"+", "-", "*", "/", "=", "<", ">", "<=", ">=", "max", "min",
+	"number?", "zero?", "positive?", "negative?", "odd?", "even?",
+	"numerator", "denominator", "rationalize",
+	"exact?", "inexact?", "exact-integer?", "nan?", "infinite?", "finite?",
+	"exact", "inexact",
+	"make-rectangular", "make-polar", "real-part", "imag-part", "magnitude",
+	"angle", "exp", "log", "sin", "cos", "tan", "asin", "acos", "atan", "sqrt",
+	"expt",
+	"write", "write-shared", "write-simple",
+	"symbol?", "pair?", "string?", "symbol->string", "string->number",
+	"char?", "char->integer", "integer->char",
+	"bytevector?", "bytevector", "make-bytevector", "bytevector-length",
+	"bytevector-u8-ref", "bytevector-u8-set!", "bytevector-copy",
+	"bytevector-copy!", "bytevector-append", "utf8->string", "string->utf8",
+	"eof-object", "eof-object?",
+}
+
+// WithPrimitives restricts the interpreter to the named primitives and
+// prelude definitions. This applies to every environment the interpreter
+// creates, including those returned by null-environment. Prelude procedures
+// and macro transformers keep working when the primitives they are written
+// with are left out, but the code a macro expands into is evaluated where the
+// macro is used, so the names it refers to must be allowed too: and expands
+// into uses of not, and let* into uses of let.
+func WithPrimitives(names ...string) Option {
+	return func(i *Interpreter) {
+		i.st.allowed = map[string]bool{}
+		for _, name := range names {
+			i.st.allowed[name] = true
+		}
+	}
+}
+
+// WithOutput sends the output of write and its variants to w instead of
+// standard output.
+func WithOutput(w io.Writer) Option {
+	return func(i *Interpreter) {
+		i.st.out = w
+	}
+}
+
+// Sandboxed restricts the interpreter to SafePrimitives.
+func Sandboxed() Option {
+	return WithPrimitives(SafePrimitives...)
+}
+
+// prelude defines the parts of the standard library that are written in
+// Scheme.
+//
+//go:embed lib.scm
+var prelude string
+
+// WithPrelude replaces the standard prelude with src.
+func WithPrelude(src string) Option {
+	return func(i *Interpreter) {
+		i.prelude = src
+	}
+}
+
+// WithoutPrelude skips loading the standard prelude, leaving only the
+// primitives defined in Go.
+func WithoutPrelude() Option {
+	return WithPrelude("")
+}
+
+// NewInterpreter returns an Interpreter with a fresh global environment into
+// which the prelude has been loaded.
+func NewInterpreter(opts ...Option) (*Interpreter, error) {
+	st := &evalState{
+		limits: DefaultLimits,
+		out:    os.Stdout,
+	}
+
+	i := &Interpreter{
+		st:      st,
+		prelude: prelude,
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	// the prelude is loaded before the allow-list is applied, so that its
+	// definitions may use primitives the allow-list leaves out
+	base := newGlobalEnv(st)
+
+	if err := i.loadPrelude(base); err != nil {
+		return nil, err
+	}
+
+	global := restrictEnv(base, st.allowed)
+
+	i.e = &env{
+		m:     map[string]*object{},
+		outer: global,
+		st:    st,
+	}
+
+	return i, nil
+}
+
+// loadPrelude evaluates the prelude into e, one form at a time.
+func (i *Interpreter) loadPrelude(e *env) error {
+	r := newReader("lib.scm", strings.NewReader(i.prelude))
+
+	for n := 1; ; n++ {
+		p, err := r.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("prelude form %d: %w", n, err)
+		}
+
+		if _, err := i.evalDatum(context.Background(), p, e); err != nil {
+			return fmt.Errorf("prelude form %d (%s): %w", n, p, err)
+		}
+	}
+}
+
+// Define binds name to f in the global environment of i. f must be called
+// with exactly arity arguments, or at least arity arguments if variadic is
+// set.
+func (i *Interpreter) Define(name string, f Func, arity int, variadic bool) {
+	i.DefineArity(name, f, Arity{Required: arity, Variadic: variadic})
+}
+
+// DefineArity binds name to f in the global environment of i, checking calls
+// against a.
+func (i *Interpreter) DefineArity(name string, f Func, a Arity) {
+	o := optProcGen(f.primitive(), a.Required, a.Optional, a.Variadic)
+
+	i.definePrimitive(name, o)
+}
+
+// DefineFunc binds name to the Go function fn in the global environment of
+// i. Arguments are converted to fn's parameter types when it is called, and
+// fn may return at most one value, optionally followed by an error that is
+// reported as a Scheme error.
+func (i *Interpreter) DefineFunc(name string, fn interface{}) error {
+	o, err := reflectProcGen(name, fn)
+	if err != nil {
+		return err
+	}
+
+	i.definePrimitive(name, o)
+
+	return nil
+}
+
+func (i *Interpreter) definePrimitive(name string, o *object) {
+	p := o.v.(primitiveProc)
+	p.name = name
+	o.v = p
+
+	i.e.m[name] = o
+}
+
+// EvalString evaluates every expression in src and returns the value of the
+// last one.
+func (i *Interpreter) EvalString(src string) (Value, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+// EvalContext is like EvalString, but stops evaluation with a
+// *CanceledError once ctx is done. Each expression in src is evaluated with
+// the interpreter's limits.
+func (i *Interpreter) EvalContext(ctx context.Context, src string) (Value, error) {
+	o, err := i.evalReader(ctx, newReader("", strings.NewReader(src)))
+
+	return Value{o: o}, err
+}
+
+// Load evaluates every expression read from r. If r has a Name method, as
+// *os.File does, errors are reported against that name.
+func (i *Interpreter) Load(r io.Reader) error {
+	return i.LoadContext(context.Background(), r)
+}
+
+// LoadContext is like Load, but stops evaluation with a *CanceledError once
+// ctx is done.
+func (i *Interpreter) LoadContext(ctx context.Context, r io.Reader) error {
+	_, err := i.evalReader(ctx, inputReader(r))
+
+	return err
+}
+
+// REPL runs a read-eval-print loop, reading from r and writing prompts,
+// results and the program's output to w, until r is exhausted or the program
+// calls exit, in which case the *ExitError is returned.
+func (i *Interpreter) REPL(r io.Reader, w io.Writer) error {
+	input := inputReader(r)
+
+	defer func(out io.Writer) {
+		i.st.out = out
+	}(i.st.out)
+	i.st.out = w
+
+	for {
+		fmt.Fprint(w, "] ")
+
+		p, err := input.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(w, "PARSE: %s\n", err)
+			continue
+		}
+
+		o, err := i.evalDatum(context.Background(), p, i.e)
+
+		var exit *ExitError
+		if errors.As(err, &exit) {
+			return exit
+		}
+
+		if err != nil {
+			fmt.Fprintf(w, "ERROR: %s\n", err)
+		} else {
+			if o != nil {
+				fmt.Fprintf(w, "%s\n", o)
+			}
+		}
+	}
+}
+
+// inputReader returns a reader for r. Standard input shares the reader used by
+// read, so that a program can read the lines that follow it.
+func inputReader(r io.Reader) *reader {
+	if r == os.Stdin {
+		return stdin()
+	}
+
+	return newReader(readerName(r), r)
+}
+
+func readerName(r io.Reader) string {
+	if n, ok := r.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+
+	return ""
+}
+
+func (i *Interpreter) evalReader(ctx context.Context, r *reader) (*object, error) {
+	var last *object
+
+	for {
+		p, err := r.read()
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		last, err = i.evalDatum(ctx, p, i.e)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (i *Interpreter) evalDatum(ctx context.Context, p *object, e *env) (*object, error) {
+	i.st.start(ctx)
+	defer i.st.finish()
+
+	if err := checkAcyclic(p); err != nil {
+		return nil, err
+	}
+
+	p, err := expand(p, e)
+	if err != nil {
+		return nil, err
+	}
+
+	return eval(p, e)
+}
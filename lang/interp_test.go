@@ -0,0 +1,444 @@
+package lang
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// namedReader is a source with a file name, as *os.File is.
+type namedReader struct {
+	*strings.Reader
+	name string
+}
+
+func (r namedReader) Name() string {
+	return r.name
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"(car\n  bar)", "foo.scm:2:3: unknown identifier bar"},
+		{"1\n\n   (1 2)", "foo.scm:3:4: type mismatch: expected procedure, got num"},
+		{"(define (f x)\n  (car x))\n(f 1)", "foo.scm:2:3: type mismatch: expected list, got num"},
+		{"(1 2", "foo.scm:1:5: unexpected end of input"},
+		{"\n\t\"a\\q\"", "foo.scm:2:3: unknown escape sequence \\q"},
+	}
+
+	for _, tt := range tests {
+		i, err := NewInterpreter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = i.Load(namedReader{strings.NewReader(tt.src), "foo.scm"})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("load %q: error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestEvalString(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"1", "1"},
+		{"(car '(a b))", "a"},
+		{"(define x 2) (define y 3) (+ x y)", "5"},
+		{"((lambda (x) (* x x)) x)", "4"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		v, err := i.EvalString(tt.src)
+		if err != nil {
+			t.Errorf("%s: %s", tt.src, err)
+			continue
+		}
+
+		if v.String() != tt.want {
+			t.Errorf("%s = %s, want %s", tt.src, v, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := i.Load(strings.NewReader("(define (sq x) (* x x))\n(define z (sq 7))")); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := i.EvalString("z"); err != nil || v.String() != "49" {
+		t.Errorf("z = %s, %v, want 49", v, err)
+	}
+
+	if err := i.Load(strings.NewReader("(define a 1) (car a) (define b 2)")); err == nil {
+		t.Errorf("load with an error succeeded")
+	}
+
+	// evaluation stops at the first error
+	if _, err := i.EvalString("b"); err == nil {
+		t.Errorf("b was defined after an error")
+	}
+}
+
+func TestREPL(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	in := "(define x 2)\n(+ x 1)\n(car x)\n)\n'done\n"
+
+	if err := i.REPL(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "] ] 3\n] ERROR: 3:1: type mismatch: expected list, got num\n" +
+		"] PARSE: 4:1: unexpected )\n] done\n] "
+	if out.String() != want {
+		t.Errorf("REPL output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	i1, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i2, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{"(define x 1)", "(define car cdr)", "(set! map 0)"} {
+		if _, err := i1.EvalString(src); err != nil {
+			t.Fatalf("%s: %s", src, err)
+		}
+	}
+
+	if _, err := i2.EvalString("x"); err == nil {
+		t.Errorf("x defined in one interpreter is visible in another")
+	}
+
+	tests := []struct {
+		i    *Interpreter
+		src  string
+		want string
+	}{
+		{i1, "(car '(1 2))", "(2)"},
+		{i2, "(car '(1 2))", "1"},
+		{i2, "(map car '((1) (2)))", "(1 2)"},
+		{i1, "(eval 'x (null-environment 7))", ""},
+		{i1, "(eval '(car '(1 2)) (null-environment 7))", "1"},
+	}
+
+	for _, tt := range tests {
+		v, err := tt.i.EvalString(tt.src)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s = %s, want an error", tt.src, v)
+		case tt.want != "" && (err != nil || v.String() != tt.want):
+			t.Errorf("%s = %s, %v, want %s", tt.src, v, err, tt.want)
+		}
+	}
+}
+
+func TestDefine(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := func(args ...Value) (Value, error) {
+		total := 0
+		for _, a := range args {
+			n, err := a.AsInt()
+			if err != nil {
+				return Value{}, err
+			}
+			total += n
+		}
+
+		return FromGo(total)
+	}
+
+	i.Define("add2", sum, 2, false)
+	i.Define("sum", sum, 1, true)
+	i.DefineArity("add-opt", sum, Arity{Required: 1, Optional: 2})
+	i.Define("fail", func(args ...Value) (Value, error) {
+		return Value{}, errors.New("failed")
+	}, 0, false)
+
+	tests := []struct {
+		src  string
+		want string
+		err  string
+	}{
+		{src: "(add2 1 2)", want: "3"},
+		{src: "(sum 1)", want: "1"},
+		{src: "(sum 1 2 3 4)", want: "10"},
+		{src: "(add-opt 1)", want: "1"},
+		{src: "(add-opt 1 2 3)", want: "6"},
+		{src: "(map (lambda (x) (add2 x x)) '(1 2))", want: "(2 4)"},
+		{src: "(add2 1)", err: "1:1: add2: argument length mismatch: 2 != 1"},
+		{src: "(sum)", err: "1:1: sum: argument length mismatch: expected at least 1, got 0"},
+		{src: "(add-opt 1 2 3 4)", err: "1:1: add-opt: argument length mismatch: expected at most 3, got 4"},
+		{src: `(add2 1 "a")`, err: "1:1: type mismatch: expected num, got string"},
+		{src: "(fail)", err: "1:1: failed"},
+	}
+
+	for _, tt := range tests {
+		v, err := i.EvalString(tt.src)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.src, err, tt.err)
+			}
+		case err != nil || v.String() != tt.want:
+			t.Errorf("%s = %s, %v, want %s", tt.src, v, err, tt.want)
+		}
+	}
+}
+
+func TestDefineFunc(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	funcs := map[string]interface{}{
+		"repeat": strings.Repeat,
+		"half": func(n int) (float64, error) {
+			if n < 0 {
+				return 0, errors.New("negative")
+			}
+			return float64(n) / 2, nil
+		},
+		"join": func(sep string, xs ...string) string {
+			return strings.Join(xs, sep)
+		},
+		"total": func(xs []int) int {
+			n := 0
+			for _, x := range xs {
+				n += x
+			}
+			return n
+		},
+		"nothing": func() {},
+		"index":   func(xs []int, k int) int { return xs[k] },
+		"value":   func(v Value) string { return v.Type() },
+	}
+
+	for name, fn := range funcs {
+		if err := i.DefineFunc(name, fn); err != nil {
+			t.Fatalf("DefineFunc(%s): %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		src  string
+		want string
+		err  string
+	}{
+		{src: `(repeat "ab" 3)`, want: `"ababab"`},
+		{src: "(half 3)", want: "1.5"},
+		{src: `(join "-")`, want: `""`},
+		{src: `(join "-" "a" 'b "c")`, want: `"a-b-c"`},
+		{src: "(total '(1 2 3))", want: "6"},
+		{src: "(total '#(4 5))", want: "9"},
+		{src: "(nothing)", want: ""},
+		{src: "(value '(1))", want: `"list"`},
+		{src: "(half -1)", err: "1:1: negative"},
+		{src: `(half "a")`, err: "1:1: half: argument 1: type mismatch: expected num, got string"},
+		{src: "(half 1.5)", err: "1:1: half: argument 1: type mismatch: expected integer, got real"},
+		{src: `(join "-" "a" 1)`, err: "1:1: join: argument 3: type mismatch: expected string, got num"},
+		{src: "(total '(1 . 2))", err: "1:1: total: argument 1: improper list (1 . 2)"},
+		{src: "(half)", err: "1:1: half: argument length mismatch: 1 != 0"},
+		{src: "(index '(1) 3)", err: "1:1: index: runtime error: index out of range [3] with length 1"},
+	}
+
+	for _, tt := range tests {
+		v, err := i.EvalString(tt.src)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.src, err, tt.err)
+			}
+		case err != nil || v.String() != tt.want:
+			t.Errorf("%s = %s, %v, want %s", tt.src, v, err, tt.want)
+		}
+	}
+
+	var nilFunc func()
+	for _, fn := range []interface{}{nil, nilFunc, 3, func() (int, int) { return 0, 0 }} {
+		if err := i.DefineFunc("bad", fn); err == nil {
+			t.Errorf("DefineFunc(%#v) succeeded", fn)
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = i.EvalContext(ctx, "(define (loop) (loop)) (loop)")
+
+	var canceled *CanceledError
+	if !errors.As(err, &canceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runaway loop: error %v, want a *CanceledError", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	if err := i.LoadContext(ctx, strings.NewReader("(define (f n) (f n)) (f 1)")); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled load: error %v, want context.Canceled", err)
+	}
+
+	// the interpreter is usable after a cancellation
+	if v, err := i.EvalString("(car '(1))"); err != nil || v.String() != "1" {
+		t.Errorf("after cancellation = %s, %v, want 1", v, err)
+	}
+}
+
+func TestNestedEval(t *testing.T) {
+	i, err := NewInterpreter(WithLimits(Limits{MaxSteps: 10000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i.Define("eval-string", func(args ...Value) (Value, error) {
+		src, err := args[0].AsString()
+		if err != nil {
+			return Value{}, err
+		}
+
+		return i.EvalString(src)
+	}, 1, false)
+
+	if v, err := i.EvalString(`(+ 1 (eval-string "(+ 2 3)"))`); err != nil || v.String() != "6" {
+		t.Errorf("nested evaluation = %s, %v, want 6", v, err)
+	}
+
+	// a nested evaluation does not reset the limits of the outer one
+	src := `(define (loop) (eval-string "1") (loop)) (loop)`
+	if _, err := i.EvalString(src); !errors.Is(err, ErrStepLimit) {
+		t.Errorf("%s: error %v, want %v", src, err, ErrStepLimit)
+	}
+
+	// nor does it stop the outer context from canceling evaluation
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	j, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j.Define("eval-string", func(args ...Value) (Value, error) {
+		src, _ := args[0].AsString()
+		return j.EvalString(src)
+	}, 1, false)
+
+	_, err = j.EvalContext(ctx, `(define (loop) (eval-string "1") (loop)) (loop)`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("canceled nested loop: error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPrelude(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for src, want := range map[string]string{
+		"(map cadr '((1 2) (3 4)))":               "(2 4)",
+		"(let* ((x 1) (y (cons x '()))) y)":       "(1)",
+		"(letrec* ((f (lambda () g)) (g 2)) (f))": "2",
+		"(list (null? '()) (list? '(1 . 2)))":     "(#t #f)",
+		"(reverse (make-list 2 'a))":              "(a a)",
+		"(cdddr '(1 2 3 4))":                      "(4)",
+	} {
+		if v, err := i.EvalString(src); err != nil || v.String() != want {
+			t.Errorf("%s = %s, %v, want %s", src, v, err, want)
+		}
+	}
+
+	i, err = NewInterpreter(WithoutPrelude())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := i.EvalString("map"); err == nil {
+		t.Errorf("map is defined without the prelude")
+	}
+
+	i, err = NewInterpreter(WithPrelude("(define (twice x) (cons x (cons x '())))"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := i.EvalString("(twice 1)"); err != nil || v.String() != "(1 1)" {
+		t.Errorf("(twice 1) = %s, %v, want (1 1)", v, err)
+	}
+
+	_, err = NewInterpreter(WithPrelude("(define a 1)\n(car a)"))
+	if want := "prelude form 2 ((car a)): lib.scm:2:1: type mismatch: expected list, got num"; err == nil || err.Error() != want {
+		t.Errorf("bad prelude: error %v, want %q", err, want)
+	}
+}
+
+type evalTest struct {
+	src  string
+	want string // the written form of the value of src
+	err  string // the error evaluating src should fail with instead
+}
+
+// testEval evaluates each test in a fresh interpreter made with opts.
+func testEval(t *testing.T, tests []evalTest, opts ...Option) {
+	t.Helper()
+
+	for _, tt := range tests {
+		i, err := NewInterpreter(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := i.EvalString(tt.src)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.src, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %s", tt.src, err)
+		case v.String() != tt.want:
+			t.Errorf("%s = %s, want %s", tt.src, v, tt.want)
+		}
+	}
+}
@@ -5,6 +5,8 @@ package lang
 
 import (
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -22,21 +24,48 @@ var idMap = map[string]int{
 	"define": DEFINE,
 }
 
+// position is a location in source text. Lines and columns start at 1.
+type position struct {
+	name string
+	line int
+	col  int
+}
+
+func (p position) String() string {
+	if p.name == "" {
+		return fmt.Sprintf("%d:%d", p.line, p.col)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", p.name, p.line, p.col)
+}
+
 type item struct {
 	t     int
 	input string
+	pos   position
 }
 
 type stateFn func(l *lexer) stateFn
 
+// lexer turns runes read from r into items. input holds the text read from r
+// that has not yet been emitted, which begins at line and col. The lexer only
+// runs when its caller asks for the next item, so it never reads further
+// ahead than the current token.
 type lexer struct {
 	name  string
-	input string
+	r     io.RuneReader
+	input []byte
 	start int
 	pos   int
 	width int
-	items chan item
+	line  int
+	col   int
+	state stateFn
+	items []item
 	idMap map[string]int
+
+	// foldCase is set by #!fold-case and cleared by #!no-fold-case.
+	foldCase bool
 }
 
 func isAlphaNumeric(r rune) bool {
@@ -44,7 +73,55 @@ func isAlphaNumeric(r rune) bool {
 }
 
 func isWhitespace(r rune) bool {
-	return r == ' ' || r == '\n' || r == '\t'
+	return r == ' ' || r == '\n' || r == '\t' || r == '\r' || r == '\f'
+}
+
+func isDelimiter(r rune) bool {
+	return isWhitespace(r) || strings.ContainsRune("()\";|", r) || r == eof
+}
+
+// isInitial reports whether r may begin an identifier. Outside ASCII this
+// follows the Unicode categories allowed by R7RS.
+func isInitial(r rune) bool {
+	if r <= unicode.MaxASCII {
+		return unicode.IsLetter(r) || strings.ContainsRune("!$%&*/:<=>?^_~", r)
+	}
+
+	return unicode.In(r, unicode.L, unicode.Mn, unicode.Nl, unicode.No,
+		unicode.Pd, unicode.Pc, unicode.Po, unicode.Sc, unicode.Sm, unicode.Sk,
+		unicode.So, unicode.Co)
+}
+
+func isSubsequent(r rune) bool {
+	return isInitial(r) || unicode.IsDigit(r) || strings.ContainsRune("+-.@", r) ||
+		unicode.In(r, unicode.Mc, unicode.Me)
+}
+
+// isPlainIdentifier reports whether s reads back as the symbol s without
+// vertical bars.
+func isPlainIdentifier(s string) bool {
+	if s == "" || s == "." {
+		return false
+	}
+
+	for _, r := range s {
+		if !isSubsequent(r) {
+			return false
+		}
+	}
+
+	if r, _ := utf8.DecodeRuneInString(s); isInitial(r) {
+		return true
+	}
+
+	// the peculiar identifiers accepted by lexNumber
+	if _, ok := parseNumber(s, 10); ok {
+		return false
+	}
+
+	t := strings.TrimLeft(s, "+-.")
+
+	return len(t) < len(s) && (t == "" || !unicode.IsDigit(rune(t[0])))
 }
 
 func lexStart(l *lexer) stateFn {
@@ -71,28 +148,47 @@ func lexStart(l *lexer) stateFn {
 			l.emit(COMMA)
 		}
 		return lexStart
-	case r == '.' && !unicode.IsDigit(l.peek()):
+	case r == '.' && isDelimiter(l.peek()):
 		l.emit(DOT)
 		return lexStart
-	case (r == '+' || r == '-') && !unicode.IsDigit(l.peek()):
-		l.emit(IDENT)
-		return lexStart
 	case r == '.' || r == '+' || r == '-' || ('0' <= r && r <= '9'):
 		l.backup()
 		return lexNumber
 	case r == '"':
 		l.ignore()
 		return lexString
+	case r == '|':
+		return lexBarIdentifier
+	case r == ';':
+		return lexLineComment
 	case r == '#':
 		switch r = l.next(); {
+		case r == '|':
+			return lexBlockComment
+		case r == ';':
+			l.emit(DATUMCOMMENT)
+			return lexStart
+		case r == '!':
+			return lexDirective
 		case r == 't' || r == 'f':
 			l.backup()
 			return lexBoolean
 		case r == '\\':
 			return lexCharacter
+		case strings.ContainsRune("xXbBoOdDeEiI", r):
+			l.rewind()
+			return lexNumber
 		case r == '(':
 			l.emit(LVEC)
 			return lexStart
+		case '0' <= r && r <= '9':
+			return lexLabel
+		case r == 'u' || r == 'U':
+			if !l.accept("8") || !l.accept("(") {
+				return l.errorf("bad # sequence")
+			}
+			l.emit(LU8VEC)
+			return lexStart
 		default:
 			return l.errorf("bad # sequence")
 		}
@@ -102,49 +198,123 @@ func lexStart(l *lexer) stateFn {
 		l.backup()
 		return lexIdentifier
 	}
+}
+
+// lexLabel lexes a datum label, either #n= or #n#.
+func lexLabel(l *lexer) stateFn {
+	l.acceptRun("0123456789")
+
+	switch l.next() {
+	case '=':
+		l.emit(LABELDEF)
+	case '#':
+		l.emit(LABELREF)
+	default:
+		return l.errorf("bad datum label")
+	}
 
 	return lexStart
 }
 
 func lexWhitespace(l *lexer) stateFn {
-	l.acceptRun(" \t\n")
+	for l.acceptFunc(isWhitespace) {
+	}
 
-	l.emit(WSPACE)
+	l.emitText(WSPACE, "")
 
 	return lexStart
 }
 
-func lexNumber(l *lexer) stateFn {
-	// Optional leading sign.
-	l.accept("+-")
+// lexDirective lexes #!fold-case and #!no-fold-case. A #! at the very start
+// of the input is the interpreter line of an executable script instead.
+func lexDirective(l *lexer) stateFn {
+	for !isDelimiter(l.peek()) {
+		l.next()
+	}
+
+	switch string(l.input[l.start+2 : l.pos]) {
+	case "fold-case":
+		l.foldCase = true
+	case "no-fold-case":
+		l.foldCase = false
+	default:
+		if l.line == 1 && l.col == 1 {
+			return lexLineComment
+		}
+
+		return l.errorf("unknown directive %s", l.text())
+	}
 
-	digits := "0123456789"
+	l.emitText(COMMENT, "")
 
-	l.acceptRun(digits)
-	if l.accept(".") {
-		l.acceptRun(digits)
+	return lexStart
+}
+
+func lexLineComment(l *lexer) stateFn {
+	for r := l.next(); r != '\n' && r != eof; r = l.next() {
 	}
 
-	if isAlphaNumeric(l.peek()) {
-		l.next()
-		return l.errorf("bad number syntax: %q", l.input[l.start:l.pos])
+	l.backup()
+	l.emitText(COMMENT, "")
+
+	return lexStart
+}
+
+// lexBlockComment lexes a #| ... |# comment, which may be nested.
+func lexBlockComment(l *lexer) stateFn {
+	depth := 1
+
+	for depth > 0 {
+		switch r := l.next(); {
+		case r == eof:
+			return l.errorf("unterminated block comment")
+		case r == '|' && l.peek() == '#':
+			l.next()
+			depth--
+		case r == '#' && l.peek() == '|':
+			l.next()
+			depth++
+		}
 	}
 
-	l.emit(NUM)
+	l.emitText(COMMENT, "")
 
 	return lexStart
 }
 
+// lexNumber lexes a token beginning like a number. Tokens that are not
+// numbers but begin with a sign or a dot are identifiers such as + and ...
+func lexNumber(l *lexer) stateFn {
+	for !isDelimiter(l.peek()) {
+		l.next()
+	}
+
+	text := l.text()
+
+	if _, ok := parseNumber(text, 10); ok {
+		l.emit(NUM)
+		return lexStart
+	}
+
+	if t := strings.TrimLeft(text, "+-."); len(t) == len(text) || (t != "" && unicode.IsDigit(rune(t[0]))) {
+		return l.errorf("bad number syntax: %q", text)
+	}
+
+	l.rewind()
+
+	return lexIdentifier
+}
+
 func lexString(l *lexer) stateFn {
 Loop:
 	for {
 		switch l.next() {
 		case '\\':
-			if r := l.next(); r != eof && r != '\n' {
+			if l.next() != eof {
 				break
 			}
 			fallthrough
-		case eof, '\n':
+		case eof:
 			return l.errorf("unterminated quoted string")
 		case '"':
 			l.backup()
@@ -152,6 +322,10 @@ Loop:
 		}
 	}
 
+	if _, err := unescape(l.text()); err != nil {
+		return l.errorf("%s", err)
+	}
+
 	l.emit(STRING)
 
 	l.next()
@@ -160,6 +334,85 @@ Loop:
 	return lexStart
 }
 
+var escapes = map[rune]rune{
+	'a':  '\a',
+	'b':  '\b',
+	't':  '\t',
+	'n':  '\n',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+	'|':  '|',
+}
+
+func isIntralineWhitespace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// unescape decodes the escape sequences in the text of a string literal or
+// a |...| identifier.
+func unescape(s string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		i += w
+
+		if r != '\\' {
+			b.WriteRune(r)
+			continue
+		}
+
+		if i == len(s) {
+			return "", fmt.Errorf("incomplete escape sequence")
+		}
+
+		r, w = utf8.DecodeRuneInString(s[i:])
+		i += w
+
+		switch {
+		case escapes[r] != 0:
+			b.WriteRune(escapes[r])
+		case r == 'x' || r == 'X':
+			end := strings.IndexByte(s[i:], ';')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated hex escape")
+			}
+
+			n, err := strconv.ParseUint(s[i:i+end], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(n)) {
+				return "", fmt.Errorf("bad hex escape \\x%s;", s[i:i+end])
+			}
+
+			b.WriteRune(rune(n))
+			i += end + 1
+		case isIntralineWhitespace(r) || r == '\n' || r == '\r':
+			// line continuation: \<intraline whitespace>*<line ending><intraline whitespace>*
+			i -= w
+			for i < len(s) && isIntralineWhitespace(rune(s[i])) {
+				i++
+			}
+
+			switch {
+			case strings.HasPrefix(s[i:], "\r\n"):
+				i += 2
+			case strings.HasPrefix(s[i:], "\n"), strings.HasPrefix(s[i:], "\r"):
+				i++
+			default:
+				return "", fmt.Errorf("bad line continuation")
+			}
+
+			for i < len(s) && isIntralineWhitespace(rune(s[i])) {
+				i++
+			}
+		default:
+			return "", fmt.Errorf("unknown escape sequence \\%c", r)
+		}
+	}
+
+	return b.String(), nil
+}
+
 func lexBoolean(l *lexer) stateFn {
 	switch r := l.next(); {
 	case r == 't' || r == 'f':
@@ -180,97 +433,215 @@ func lexSymbol(l *lexer) stateFn {
 }
 
 func lexIdentifier(l *lexer) stateFn {
-	letter := "abcdefghijklmnopqrstuvwxyz"
-	letter += "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	digit := "0123456789"
-	specialInit := "!$%&*/:<=>?^_~"
-	explicitSign := "+-"
-	specialSubseq := explicitSign + ".@"
-
-	initial := letter + specialInit
-	subseq := initial + digit + specialSubseq
-
-	if !l.accept(initial) {
+	// peculiar identifiers such as + and ... are checked by lexNumber
+	if !l.acceptFunc(isInitial) && !l.accept("+-.") {
 		return l.errorf("bad identifier")
 	}
 
-	l.acceptRun(subseq)
+	for l.acceptFunc(isSubsequent) {
+	}
 
-	idText := l.input[l.start:l.pos]
+	idText := l.text()
+	if l.foldCase {
+		idText = strings.ToLower(idText)
+	}
 
 	glog.V(3).Infof("checking for id text %s", idText)
 
 	if id, ok := l.idMap[idText]; ok {
 		glog.V(3).Infof("emitting special ID %s", idText)
-		l.emit(id)
+		l.emitText(id, idText)
 	} else {
-		l.emit(IDENT)
+		l.emitText(IDENT, idText)
 	}
 
 	return lexStart
 }
 
+// lexBarIdentifier lexes a |...| identifier, which may contain any character
+// and the escapes allowed in strings. The item holds the decoded name.
+func lexBarIdentifier(l *lexer) stateFn {
+	for r := l.next(); r != '|'; r = l.next() {
+		if r == '\\' {
+			r = l.next()
+		}
+
+		if r == eof {
+			return l.errorf("unterminated |identifier|")
+		}
+	}
+
+	name, err := unescape(string(l.input[l.start+1 : l.pos-1]))
+	if err != nil {
+		return l.errorf("%s", err)
+	}
+
+	l.emitText(IDENT, name)
+
+	return lexStart
+}
+
 func lexCharacter(l *lexer) stateFn {
 	glog.V(3).Infof("lexing character")
-	if l.next() == 'x' {
-		l.acceptRun("0123456789abcdefABCDEF")
+	if r := l.next(); r == eof {
+		return l.errorf("bad character")
+	} else if isAlphaNumeric(r) {
+		for isAlphaNumeric(l.peek()) {
+			l.next()
+		}
+	}
+
+	text := l.text()
+	if l.foldCase && utf8.RuneCountInString(text) > 3 {
+		text = strings.ToLower(text)
+	}
+
+	if _, err := decodeChar(text); err != nil {
+		return l.errorf("%s", err)
 	}
 
-	l.emit(CHAR)
+	l.emitText(CHAR, text)
 
 	return lexStart
 }
 
-func newLexer(input string, start stateFn) *lexer {
+var charNames = map[string]rune{
+	"alarm":     '\a',
+	"backspace": '\b',
+	"delete":    0x7f,
+	"escape":    0x1b,
+	"newline":   '\n',
+	"null":      0,
+	"nul":       0,
+	"return":    '\r',
+	"space":     ' ',
+	"tab":       '\t',
+}
+
+// decodeChar returns the character named by the text of a #\ literal.
+func decodeChar(s string) (rune, error) {
+	name := strings.TrimPrefix(s, "#\\")
+
+	if r, w := utf8.DecodeRuneInString(name); w == len(name) {
+		return r, nil
+	}
+
+	if r, ok := charNames[name]; ok {
+		return r, nil
+	}
+
+	if name[0] == 'x' || name[0] == 'X' {
+		n, err := strconv.ParseUint(name[1:], 16, 32)
+		if err == nil && utf8.ValidRune(rune(n)) {
+			return rune(n), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown character %s", s)
+}
+
+func newLexer(name string, r io.RuneReader, start stateFn) *lexer {
 	l := &lexer{
-		input: input,
-		items: make(chan item, 2),
+		name:  name,
+		r:     r,
+		line:  1,
+		col:   1,
+		state: start,
 		idMap: idMap,
 	}
 
-	go l.run(start)
-
 	return l
 }
 
-func (l *lexer) run(start stateFn) {
-	for state := start; state != nil; {
-		state = state(l)
+// nextItem runs the lexer until an item is available, returning false once
+// the input is exhausted.
+func (l *lexer) nextItem() (item, bool) {
+	for len(l.items) == 0 {
+		if l.state == nil {
+			return item{}, false
+		}
+
+		l.state = l.state(l)
 	}
 
-	close(l.items)
+	i := l.items[0]
+	l.items = l.items[1:]
+
+	return i, true
+}
+
+func (l *lexer) position() position {
+	return position{
+		name: l.name,
+		line: l.line,
+		col:  l.col,
+	}
+}
+
+// text returns the source text of the current token.
+func (l *lexer) text() string {
+	return string(l.input[l.start:l.pos])
 }
 
 func (l *lexer) emit(t int) {
+	l.emitText(t, l.text())
+}
+
+// emitText emits an item of type t for the current token, with text in place
+// of the source text.
+func (l *lexer) emitText(t int, text string) {
 	i := item{
 		t:     t,
-		input: l.input[l.start:l.pos],
+		input: text,
+		pos:   l.position(),
 	}
 
-	l.items <- i
-	l.start = l.pos
+	l.items = append(l.items, i)
+	l.ignore()
 }
 
 func (l *lexer) next() (r rune) {
 	if l.pos >= len(l.input) {
-		l.width = 0
-		return eof
+		r, _, err := l.r.ReadRune()
+		if err != nil {
+			l.width = 0
+			return eof
+		}
+
+		l.input = utf8.AppendRune(l.input, r)
 	}
 
-	r, l.width = utf8.DecodeRuneInString(l.input[l.pos:])
+	r, l.width = utf8.DecodeRune(l.input[l.pos:])
 	l.pos += l.width
 
 	return r
 }
 
+// ignore discards the text read since the last emit.
 func (l *lexer) ignore() {
-	l.start = l.pos
+	for _, r := range string(l.input[:l.pos]) {
+		if r == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+	}
+
+	l.input = l.input[l.pos:]
+	l.start = 0
+	l.pos = 0
 }
 
 func (l *lexer) backup() {
 	l.pos -= l.width
 }
 
+// rewind backs up to the start of the current token.
+func (l *lexer) rewind() {
+	l.pos = l.start
+}
+
 func (l *lexer) peek() rune {
 	r := l.next()
 	l.backup()
@@ -287,6 +658,15 @@ func (l *lexer) accept(valid string) bool {
 	return false
 }
 
+func (l *lexer) acceptFunc(valid func(rune) bool) bool {
+	if r := l.next(); r != eof && valid(r) {
+		return true
+	}
+
+	l.backup()
+	return false
+}
+
 func (l *lexer) acceptRun(valid string) {
 	for strings.IndexRune(valid, l.next()) >= 0 {
 	}
@@ -294,10 +674,26 @@ func (l *lexer) acceptRun(valid string) {
 	l.backup()
 }
 
+// errorf emits an error item and skips the rest of the line, so that lexing
+// can resume with the next line of interactive input.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{
+	l.items = append(l.items, item{
 		t:     -1,
 		input: fmt.Sprintf(format, args...),
+		pos:   l.position(),
+	})
+
+	return lexSkipLine
+}
+
+func lexSkipLine(l *lexer) stateFn {
+	for r := l.next(); r != '\n'; r = l.next() {
+		if r == eof {
+			return nil
+		}
 	}
-	return nil
+
+	l.ignore()
+
+	return lexStart
 }
@@ -1,65 +1,386 @@
 package lang
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
 	"strconv"
+	"strings"
 )
 
+// number is a Scheme number. Integers are held in intVal when they fit in an
+// int and in bigVal otherwise, and other exact numbers in ratVal. Inexact
+// numbers are held in floatVal, or in complexVal if they are not real.
 type number struct {
-	t        objType
-	intVal   int
-	floatVal float64
+	t          objType
+	intVal     int
+	bigVal     *big.Int
+	ratVal     *big.Rat
+	floatVal   float64
+	complexVal complex128
 }
 
 func (n number) String() string {
 	switch n.t {
 	case intT:
 		return fmt.Sprintf("%d", n.intVal)
+	case bigT:
+		return n.bigVal.String()
+	case ratT:
+		return n.ratVal.RatString()
 	case realT:
-		return fmt.Sprintf("%g", n.floatVal)
+		switch {
+		case math.IsInf(n.floatVal, 1):
+			return "+inf.0"
+		case math.IsInf(n.floatVal, -1):
+			return "-inf.0"
+		case math.IsNaN(n.floatVal):
+			return "+nan.0"
+		}
+
+		// keep inexact integers distinct from exact ones
+		s := strconv.FormatFloat(n.floatVal, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case complexT:
+		im := realNum(imag(n.complexVal)).String()
+		if im[0] != '+' && im[0] != '-' {
+			im = "+" + im
+		}
+		return realNum(real(n.complexVal)).String() + im + "i"
 	default:
 		return "?"
 	}
 }
 
 type unaryOp func(n number) number
-type binaryOp func(n1, n2 number) number
+type binaryOp func(n1, n2 number) (number, error)
 
 func parseNum(s string) number {
-	if i, err := strconv.Atoi(s); err == nil {
-		return number{
-			t:      intT,
-			intVal: i,
+	n, _ := parseNumber(s, 10)
+
+	return n
+}
+
+// parseNumber parses the external representation of a number, with radix
+// used unless s has a radix prefix. It reports false if s is not a number.
+func parseNumber(s string, radix int) (number, bool) {
+	exactness := byte(0)
+	hasRadix := false
+
+	for len(s) >= 2 && s[0] == '#' {
+		switch c := s[1] | 0x20; c {
+		case 'e', 'i':
+			if exactness != 0 {
+				return number{}, false
+			}
+			exactness = c
+		case 'x', 'b', 'o', 'd':
+			if hasRadix {
+				return number{}, false
+			}
+			hasRadix = true
+			radix = map[byte]int{'x': 16, 'b': 2, 'o': 8, 'd': 10}[c]
+		default:
+			return number{}, false
+		}
+
+		s = s[2:]
+	}
+
+	exact, inexact, ok := parseReal(s, radix)
+	if !ok {
+		// complex numbers are always inexact
+		c, ok := parseComplex(s, radix)
+		if !ok || exactness == 'e' {
+			return number{}, false
+		}
+
+		return complexNum(c), true
+	}
+
+	switch {
+	case exact != nil && exactness == 'i':
+		f, _ := exact.Float64()
+		return realNum(f), true
+	case exact == nil && exactness == 'e':
+		// read decimals exactly rather than through their approximation,
+		// which may have overflowed
+		var ok bool
+		if exact, ok = new(big.Rat).SetString(s); !ok {
+			if math.IsInf(inexact, 0) || math.IsNaN(inexact) {
+				return number{}, false
+			}
+
+			exact = new(big.Rat).SetFloat64(inexact)
+		}
+	}
+
+	if exact == nil {
+		return realNum(inexact), true
+	}
+
+	return exactNum(exact), true
+}
+
+// parseReal parses a signed real number without prefixes, returning either
+// its exact value or, for decimals and special values, its inexact value.
+func parseReal(s string, radix int) (*big.Rat, float64, bool) {
+	switch strings.ToLower(s) {
+	case "+inf.0":
+		return nil, math.Inf(1), true
+	case "-inf.0":
+		return nil, math.Inf(-1), true
+	case "+nan.0", "-nan.0":
+		return nil, math.NaN(), true
+	}
+
+	sign := ""
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		sign, s = s[:1], s[1:]
+	}
+
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		num, ok1 := parseUinteger(s[:i], radix)
+		den, ok2 := parseUinteger(s[i+1:], radix)
+		if !ok1 || !ok2 || den.Sign() == 0 {
+			return nil, 0, false
+		}
+
+		r := new(big.Rat).SetFrac(num, den)
+		if sign == "-" {
+			r.Neg(r)
+		}
+
+		return r, 0, true
+	}
+
+	if n, ok := parseUinteger(s, radix); ok {
+		if sign == "-" {
+			n.Neg(n)
+		}
+
+		return new(big.Rat).SetInt(n), 0, true
+	}
+
+	if radix != 10 || !isDecimal(s) {
+		return nil, 0, false
+	}
+
+	f, err := strconv.ParseFloat(sign+s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, 0, false
+	}
+
+	return nil, f, true
+}
+
+func parseUinteger(s string, radix int) (*big.Int, bool) {
+	if s == "" || s[0] == '+' || s[0] == '-' {
+		return nil, false
+	}
+
+	return new(big.Int).SetString(s, radix)
+}
+
+// isDecimal reports whether s is an unsigned decimal with at least one digit
+// and an optional exponent.
+func isDecimal(s string) bool {
+	digits := 0
+	i := 0
+
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+		digits++
+	}
+
+	if i < len(s) && s[i] == '.' {
+		i++
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+			digits++
+		}
+	}
+
+	if digits == 0 {
+		return false
+	}
+
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+
+		start := i
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+
+		if i == start {
+			return false
+		}
+	}
+
+	return i == len(s)
+}
+
+func intNum(i int) number {
+	return number{
+		t:      intT,
+		intVal: i,
+	}
+}
+
+// bigNum returns the integer b as a number, demoting it to an int if it fits.
+func bigNum(b *big.Int) number {
+	if b.IsInt64() {
+		if i := b.Int64(); int64(int(i)) == i {
+			return intNum(int(i))
 		}
 	}
 
-	if f, err := strconv.ParseFloat(s, 64); err == nil {
+	return number{
+		t:      bigT,
+		bigVal: b,
+	}
+}
+
+// ratNum returns the exact number r, demoting it to an integer if it is one.
+func ratNum(r *big.Rat) number {
+	if r.IsInt() {
+		return bigNum(new(big.Int).Set(r.Num()))
+	}
+
+	return number{
+		t:      ratT,
+		ratVal: r,
+	}
+}
+
+func realNum(f float64) number {
+	return number{
+		t:        realT,
+		floatVal: f,
+	}
+}
+
+// exactNum converts an exact value to a number.
+func exactNum(r *big.Rat) number {
+	return ratNum(new(big.Rat).Set(r))
+}
+
+// big returns the value of an integer as a *big.Int.
+func (n number) big() *big.Int {
+	if n.t == bigT {
+		return n.bigVal
+	}
+
+	return big.NewInt(int64(n.intVal))
+}
+
+// rat returns the value of an exact number as a *big.Rat.
+func (n number) rat() *big.Rat {
+	if n.t == ratT {
+		return n.ratVal
+	}
+
+	return new(big.Rat).SetInt(n.big())
+}
+
+func (n number) float() float64 {
+	switch n.t {
+	case intT:
+		return float64(n.intVal)
+	case bigT:
+		f, _ := new(big.Float).SetInt(n.bigVal).Float64()
+		return f
+	case ratT:
+		f, _ := n.ratVal.Float64()
+		return f
+	}
+
+	return n.floatVal
+}
+
+// as converts n to the numeric type t, which is no lower than n's.
+func (n number) as(t objType) number {
+	switch t {
+	case bigT:
+		return number{
+			t:      bigT,
+			bigVal: n.big(),
+		}
+	case ratT:
 		return number{
-			t:        realT,
-			floatVal: f,
+			t:      ratT,
+			ratVal: n.rat(),
+		}
+	case realT:
+		return realNum(n.float())
+	case complexT:
+		return number{
+			t:          complexT,
+			complexVal: n.complex(),
 		}
 	}
 
-	return number{}
+	return n
 }
 
-func applyBinaryOp(f binaryOp, n1, n2 number) number {
+// sign returns -1, 0 or 1 according to the sign of the real number n, or 0
+// if n is NaN.
+func (n number) sign() int {
+	switch {
+	case n.t == bigT:
+		return n.bigVal.Sign()
+	case n.t == ratT:
+		return n.ratVal.Sign()
+	case n.t == intT && n.intVal < 0, n.t == realT && n.floatVal < 0:
+		return -1
+	case n.t == intT && n.intVal > 0, n.t == realT && n.floatVal > 0:
+		return 1
+	}
+
+	return 0
+}
+
+// isExact reports whether n is exact. Inexactness is contagious: arithmetic
+// on an inexact number gives an inexact result.
+func (n number) isExact() bool {
+	return n.t < realT
+}
+
+// promote converts whichever of n1 and n2 has the lower numeric type to the
+// type of the other.
+func promote(n1, n2 number) (number, number) {
 	if n1.t > n2.t {
-		n2.t = realT
-		n2.floatVal = float64(n2.intVal)
+		n2 = n2.as(n1.t)
 	}
 
 	if n2.t > n1.t {
-		n1.t = realT
-		n1.floatVal = float64(n1.intVal)
+		n1 = n1.as(n2.t)
 	}
 
+	return n1, n2
+}
+
+func applyBinaryOp(f binaryOp, n1, n2 number) (number, error) {
+	n1, n2 = promote(n1, n2)
+
 	return f(n1, n2)
 }
 
 func binaryOpGen(f binaryOp, initial number, isSubDiv bool) primitiveFunc {
 	return func(o ...*object) (*object, error) {
-		var result number
+		var (
+			result number
+			err    error
+		)
 
 		switch {
 		case len(o) == 0:
@@ -70,7 +391,10 @@ func binaryOpGen(f binaryOp, initial number, isSubDiv bool) primitiveFunc {
 				return nil, typeMismatch(numT, n.t)
 			}
 			if isSubDiv {
-				result = applyBinaryOp(f, initial, n.v.(number))
+				result, err = applyBinaryOp(f, initial, n.v.(number))
+				if err != nil {
+					return nil, err
+				}
 			} else {
 				result = n.v.(number)
 			}
@@ -87,7 +411,10 @@ func binaryOpGen(f binaryOp, initial number, isSubDiv bool) primitiveFunc {
 					return nil, typeMismatch(numT, n.t)
 				}
 
-				result = applyBinaryOp(f, result, n.v.(number))
+				result, err = applyBinaryOp(f, result, n.v.(number))
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
 
@@ -100,83 +427,457 @@ func binaryOpGen(f binaryOp, initial number, isSubDiv bool) primitiveFunc {
 	}
 }
 
-func add(n1, n2 number) number {
+// compare returns -1, 0 or 1 as n1 is less than, equal to or greater than
+// n2. It reports false if they are unordered, as NaN is with every number
+// and a non-real number is with every number but itself.
+func compare(n1, n2 number) (int, bool) {
+	n1, n2 = promote(n1, n2)
+
 	switch n1.t {
 	case intT:
-		return number{
-			t:      intT,
-			intVal: n1.intVal + n2.intVal,
+		switch {
+		case n1.intVal < n2.intVal:
+			return -1, true
+		case n1.intVal > n2.intVal:
+			return 1, true
 		}
+		return 0, true
+	case bigT:
+		return n1.bigVal.Cmp(n2.bigVal), true
+	case ratT:
+		return n1.ratVal.Cmp(n2.ratVal), true
 	case realT:
-		return number{
-			t:        realT,
-			floatVal: n1.floatVal + n2.floatVal,
+		switch {
+		case n1.floatVal < n2.floatVal:
+			return -1, true
+		case n1.floatVal > n2.floatVal:
+			return 1, true
 		}
+		return 0, n1.floatVal == n2.floatVal
+	case complexT:
+		return 0, n1.complexVal == n2.complexVal
 	}
 
 	panic("unknown number type")
 }
 
-func sub(n1, n2 number) number {
+func numEq(c int) bool {
+	return c == 0
+}
+
+func numLt(c int) bool {
+	return c < 0
+}
+
+func numGt(c int) bool {
+	return c > 0
+}
+
+func numLe(c int) bool {
+	return c <= 0
+}
+
+func numGe(c int) bool {
+	return c >= 0
+}
+
+// compareGen returns a procedure reporting whether test holds for the
+// comparison of each of its arguments with the next. If ordered is set, the
+// arguments must be real.
+func compareGen(test func(c int) bool, ordered bool) primitiveFunc {
+	arg := numArg
+	if ordered {
+		arg = realArg
+	}
+
+	return func(o ...*object) (*object, error) {
+		nums := make([]number, len(o))
+		for i := range o {
+			n, err := arg(o[i])
+			if err != nil {
+				return nil, err
+			}
+
+			nums[i] = n
+		}
+
+		for i := 1; i < len(nums); i++ {
+			if c, ok := compare(nums[i-1], nums[i]); !ok || !test(c) {
+				return boolObj(false), nil
+			}
+		}
+
+		return boolObj(true), nil
+	}
+}
+
+// extremumGen returns a procedure giving the argument that test prefers in
+// comparison with each of the others, such as the largest. The result is
+// inexact if any argument is, and NaN if any argument is NaN.
+func extremumGen(test func(c int) bool) primitiveFunc {
+	return func(o ...*object) (*object, error) {
+		result, err := realArg(o[0])
+		if err != nil {
+			return nil, err
+		}
+
+		exact := result.isExact()
+
+		for _, x := range o[1:] {
+			n, err := realArg(x)
+			if err != nil {
+				return nil, err
+			}
+
+			exact = exact && n.isExact()
+
+			c, ok := compare(n, result)
+			switch {
+			case !ok:
+				result = realNum(math.NaN())
+			case test(c):
+				result = n
+			}
+		}
+
+		if !exact {
+			result = result.as(realT)
+		}
+
+		return numObj(result), nil
+	}
+}
+
+func add(n1, n2 number) (number, error) {
 	switch n1.t {
 	case intT:
-		return number{
-			t:      intT,
-			intVal: n1.intVal - n2.intVal,
+		if s := n1.intVal + n2.intVal; (s > n1.intVal) == (n2.intVal > 0) {
+			return intNum(s), nil
 		}
+		fallthrough
+	case bigT:
+		return bigNum(new(big.Int).Add(n1.big(), n2.big())), nil
+	case ratT:
+		return ratNum(new(big.Rat).Add(n1.ratVal, n2.ratVal)), nil
 	case realT:
-		return number{
-			t:        realT,
-			floatVal: n1.floatVal - n2.floatVal,
-		}
+		return realNum(n1.floatVal + n2.floatVal), nil
+	case complexT:
+		return complexNum(n1.complexVal + n2.complexVal), nil
 	}
 
 	panic("unknown number type")
 }
 
-func mul(n1, n2 number) number {
+func sub(n1, n2 number) (number, error) {
 	switch n1.t {
 	case intT:
-		return number{
-			t:      intT,
-			intVal: n1.intVal * n2.intVal,
+		if d := n1.intVal - n2.intVal; (d < n1.intVal) == (n2.intVal > 0) {
+			return intNum(d), nil
 		}
+		fallthrough
+	case bigT:
+		return bigNum(new(big.Int).Sub(n1.big(), n2.big())), nil
+	case ratT:
+		return ratNum(new(big.Rat).Sub(n1.ratVal, n2.ratVal)), nil
 	case realT:
-		return number{
-			t:        realT,
-			floatVal: n1.floatVal * n2.floatVal,
-		}
+		return realNum(n1.floatVal - n2.floatVal), nil
+	case complexT:
+		return complexNum(n1.complexVal - n2.complexVal), nil
 	}
 
 	panic("unknown number type")
 }
 
-func div(n1, n2 number) number {
+func mul(n1, n2 number) (number, error) {
 	switch n1.t {
 	case intT:
-		return number{
-			t:      intT,
-			intVal: n1.intVal / n2.intVal,
+		a, b := n1.intVal, n2.intVal
+		if p := a * b; a == 0 || (p/a == b && !(a == -1 && b == math.MinInt)) {
+			return intNum(p), nil
 		}
+		fallthrough
+	case bigT:
+		return bigNum(new(big.Int).Mul(n1.big(), n2.big())), nil
+	case ratT:
+		return ratNum(new(big.Rat).Mul(n1.ratVal, n2.ratVal)), nil
 	case realT:
-		return number{
-			t:        realT,
-			floatVal: n1.floatVal / n2.floatVal,
+		return realNum(n1.floatVal * n2.floatVal), nil
+	case complexT:
+		return complexNum(n1.complexVal * n2.complexVal), nil
+	}
+
+	panic("unknown number type")
+}
+
+// div divides exact numbers exactly, giving a rational unless the quotient
+// is an integer.
+func div(n1, n2 number) (number, error) {
+	switch n1.t {
+	case intT, bigT, ratT:
+		d := n2.rat()
+		if d.Sign() == 0 {
+			return number{}, errors.New("division by zero")
 		}
+		return ratNum(new(big.Rat).Quo(n1.rat(), d)), nil
+	case realT:
+		return realNum(n1.floatVal / n2.floatVal), nil
+	case complexT:
+		return complexNum(n1.complexVal / n2.complexVal), nil
 	}
 
 	panic("unknown number type")
 }
 
+// ratPartGen returns a procedure giving part of a number as a fraction in
+// lowest terms, such as its numerator. The part of an inexact number is
+// inexact.
+func ratPartGen(part func(r *big.Rat) *big.Int) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		n, err := realArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if n.isExact() {
+			return numObj(bigNum(new(big.Int).Set(part(n.rat())))), nil
+		}
+
+		r := new(big.Rat).SetFloat64(n.floatVal)
+		if r == nil {
+			return nil, fmt.Errorf("%s is not rational", n)
+		}
+
+		return numObj(realNum(bigNum(part(r)).float())), nil
+	}
+}
+
+// rationalize returns the simplest rational number differing from its first
+// argument by no more than its second.
+func rationalize(args ...*object) (*object, error) {
+	x, err := realArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := realArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if x.isExact() && y.isExact() {
+		r, d := x.rat(), new(big.Rat).Abs(y.rat())
+		lo, hi := new(big.Rat).Sub(r, d), new(big.Rat).Add(r, d)
+
+		return numObj(ratNum(simplestRational(lo, hi))), nil
+	}
+
+	fx, fy := x.float(), math.Abs(y.float())
+
+	switch {
+	case math.IsNaN(fx) || math.IsNaN(fy):
+		return numObj(realNum(math.NaN())), nil
+	case math.IsInf(fy, 0):
+		if math.IsInf(fx, 0) {
+			return numObj(realNum(math.NaN())), nil
+		}
+		return numObj(realNum(0)), nil
+	case math.IsInf(fx, 0):
+		return numObj(realNum(fx)), nil
+	}
+
+	r, d := new(big.Rat).SetFloat64(fx), new(big.Rat).SetFloat64(fy)
+	lo, hi := new(big.Rat).Sub(r, d), new(big.Rat).Add(r, d)
+
+	f, _ := simplestRational(lo, hi).Float64()
+
+	return numObj(realNum(f)), nil
+}
+
+// simplestRational returns the rational number with the smallest denominator
+// in [lo, hi].
+func simplestRational(lo, hi *big.Rat) *big.Rat {
+	switch {
+	case lo.Sign() <= 0 && hi.Sign() >= 0:
+		return new(big.Rat)
+	case hi.Sign() < 0:
+		r := simplestRational(new(big.Rat).Neg(hi), new(big.Rat).Neg(lo))
+		return r.Neg(r)
+	}
+
+	// lo and hi are positive, so truncating them gives their floors
+	fl := new(big.Rat).SetInt(new(big.Int).Quo(lo.Num(), lo.Denom()))
+	fh := new(big.Int).Quo(hi.Num(), hi.Denom())
+
+	switch {
+	case fl.Cmp(lo) == 0:
+		return fl
+	case fl.Num().Cmp(fh) < 0:
+		return fl.Add(fl, big.NewRat(1, 1))
+	}
+
+	r := simplestRational(
+		new(big.Rat).Inv(new(big.Rat).Sub(hi, fl)),
+		new(big.Rat).Inv(new(big.Rat).Sub(lo, fl)))
+
+	return r.Add(fl, r.Inv(r))
+}
+
+func numArg(o *object) (number, error) {
+	if !isNum(o) {
+		return number{}, typeMismatch(numT, o.t)
+	}
+
+	return o.v.(number), nil
+}
+
+func realArg(o *object) (number, error) {
+	n, err := numArg(o)
+	if err == nil && n.t == complexT {
+		err = typeMismatch(realT, complexT)
+	}
+
+	return n, err
+}
+
+// numPredGen returns a predicate on numbers.
+func numPredGen(f func(n number) bool) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		o := args[0]
+		if !isNum(o) {
+			return nil, typeMismatch(numT, o.t)
+		}
+
+		return boolObj(f(o.v.(number))), nil
+	}
+}
+
+// isZero is false for every non-real number, since those with a zero
+// imaginary part are held as reals.
+func isZero(n number) bool {
+	return n.t != complexT && n.sign() == 0 && !isNaN(n)
+}
+
+func isPositive(n number) bool {
+	return n.sign() > 0
+}
+
+func isNegative(n number) bool {
+	return n.sign() < 0
+}
+
+// realPredGen returns a predicate on real numbers.
+func realPredGen(f func(n number) bool) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		n, err := realArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return boolObj(f(n)), nil
+	}
+}
+
+// parity returns 1 if the integer o is odd and 0 if it is even.
+func parity(o *object) (int, error) {
+	n, err := realArg(o)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case n.t == intT:
+		return n.intVal & 1, nil
+	case n.t == bigT:
+		return int(n.bigVal.Bit(0)), nil
+	case n.t == realT && n.floatVal == math.Trunc(n.floatVal) && !math.IsInf(n.floatVal, 0):
+		return int(math.Abs(math.Mod(n.floatVal, 2))), nil
+	}
+
+	return 0, typeMismatch(intT, n.t)
+}
+
+func isOdd(args ...*object) (*object, error) {
+	p, err := parity(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return boolObj(p == 1), nil
+}
+
+func isEven(args ...*object) (*object, error) {
+	p, err := parity(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return boolObj(p == 0), nil
+}
+
+func isInexact(n number) bool {
+	return !n.isExact()
+}
+
+func isExactInteger(n number) bool {
+	return n.t == intT || n.t == bigT
+}
+
+func isNaN(n number) bool {
+	return n.t == realT && math.IsNaN(n.floatVal) ||
+		n.t == complexT && cmplx.IsNaN(n.complexVal)
+}
+
+func isInfinite(n number) bool {
+	return n.t == realT && math.IsInf(n.floatVal, 0) ||
+		n.t == complexT && cmplx.IsInf(n.complexVal)
+}
+
+func isFinite(n number) bool {
+	return !isNaN(n) && !isInfinite(n)
+}
+
+func exact(args ...*object) (*object, error) {
+	o := args[0]
+	if !isNum(o) {
+		return nil, typeMismatch(numT, o.t)
+	}
+
+	n := o.v.(number)
+	if n.isExact() {
+		return o, nil
+	}
+
+	r := new(big.Rat).SetFloat64(n.floatVal)
+	if r == nil || n.t == complexT {
+		return nil, fmt.Errorf("%s has no exact representation", n)
+	}
+
+	return numObj(ratNum(r)), nil
+}
+
+func inexact(args ...*object) (*object, error) {
+	o := args[0]
+	if !isNum(o) {
+		return nil, typeMismatch(numT, o.t)
+	}
+
+	n := o.v.(number)
+	if !n.isExact() {
+		return o, nil
+	}
+
+	return numObj(n.as(realT)), nil
+}
+
 func floor(n number) number {
 	switch n.t {
-	case intT:
+	case intT, bigT:
 		return n
+	case ratT:
+		// the denominator is positive, so Euclidean division rounds down
+		return bigNum(new(big.Int).Div(n.ratVal.Num(), n.ratVal.Denom()))
 	case realT:
-		return number{
-			t: intT,
-			intVal: int(n.floatVal),
-		}
+		return realNum(math.Floor(n.floatVal))
 	}
 
 	panic("unknown number type")
@@ -184,13 +885,14 @@ func floor(n number) number {
 
 func ceiling(n number) number {
 	switch n.t {
-	case intT:
+	case intT, bigT:
 		return n
+	case ratT:
+		// rationals are never integers, so this is one more than the floor
+		c, _ := add(floor(n), intNum(1))
+		return c
 	case realT:
-		return number{
-			t: intT,
-			intVal: int(n.floatVal + 0.5),
-		}
+		return realNum(math.Ceil(n.floatVal))
 	}
 
 	panic("unknown number type")
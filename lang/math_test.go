@@ -0,0 +1,330 @@
+package lang
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		exact bool
+	}{
+		// integers
+		{"0", "0", true},
+		{"-17", "-17", true},
+		{"+17", "17", true},
+		{"123456789012345678901234567890", "123456789012345678901234567890", true},
+
+		// radix prefixes
+		{"#xff", "255", true},
+		{"#XFF", "255", true},
+		{"#b-101", "-5", true},
+		{"#o17", "15", true},
+		{"#d10", "10", true},
+
+		// rationals
+		{"1/2", "1/2", true},
+		{"-6/4", "-3/2", true},
+		{"4/2", "2", true},
+		{"#x1/a", "1/10", true},
+
+		// decimals
+		{"1.5", "1.5", false},
+		{".5", "0.5", false},
+		{"5.", "5.0", false},
+		{"1e3", "1000.0", false},
+		{"-2.5E-1", "-0.25", false},
+		{"1e400", "+inf.0", false},
+
+		// exactness prefixes
+		{"#e1.5", "3/2", true},
+		{"#e1e3", "1000", true},
+		{"#e1e400", "1" + strings.Repeat("0", 400), true},
+		{"#i1/2", "0.5", false},
+		{"#i3", "3.0", false},
+		{"#x#e10", "16", true},
+		{"#e#x10", "16", true},
+
+		// special values
+		{"+inf.0", "+inf.0", false},
+		{"-inf.0", "-inf.0", false},
+		{"+nan.0", "+nan.0", false},
+		{"-NaN.0", "+nan.0", false},
+	}
+
+	for _, tt := range tests {
+		n, ok := parseNumber(tt.in, 10)
+		if !ok {
+			t.Errorf("parseNumber(%q) failed", tt.in)
+			continue
+		}
+
+		if got := n.String(); got != tt.want {
+			t.Errorf("parseNumber(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+
+		if n.isExact() != tt.exact {
+			t.Errorf("parseNumber(%q) exactness = %v, want %v", tt.in, n.isExact(), tt.exact)
+		}
+	}
+}
+
+func TestParseNumberInvalid(t *testing.T) {
+	tests := []string{
+		"", "+", "-", ".", "...", "1/", "/2", "1/0", "1/-2", "1.2.3", "1e",
+		"e3", "#x", "#xg", "#b2", "#x1.5", "#e#e1", "#x#b1", "#q1", "#e+inf.0",
+		"#e+nan.0", "inf.0", "abc",
+	}
+
+	for _, in := range tests {
+		if n, ok := parseNumber(in, 10); ok {
+			t.Errorf("parseNumber(%q) = %s, want failure", in, n)
+		}
+	}
+}
+
+func TestParseNumberRadix(t *testing.T) {
+	tests := []struct {
+		in    string
+		radix int
+		want  string
+	}{
+		{"ff", 16, "255"},
+		{"-101", 2, "-5"},
+		{"#d99", 16, "99"},
+		{"1/11", 2, "1/3"},
+	}
+
+	for _, tt := range tests {
+		n, ok := parseNumber(tt.in, tt.radix)
+		if !ok {
+			t.Errorf("parseNumber(%q, %d) failed", tt.in, tt.radix)
+			continue
+		}
+
+		if got := n.String(); got != tt.want {
+			t.Errorf("parseNumber(%q, %d) = %s, want %s", tt.in, tt.radix, got, tt.want)
+		}
+	}
+}
+
+func TestStringToNumber(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`(string->number "42")`, "42"},
+		{`(string->number "ff" 16)`, "255"},
+		{`(string->number "#b101" 16)`, "5"},
+		{`(string->number "1e2")`, "100.0"},
+		{`(string->number "#e1e400")`, "1" + strings.Repeat("0", 400)},
+		{`(string->number "abc")`, "#f"},
+		{`(string->number "")`, "#f"},
+	}
+
+	for _, tt := range tests {
+		if v, err := i.EvalString(tt.src); err != nil || v.String() != tt.want {
+			t.Errorf("%s = %s, %v, want %s", tt.src, v, err, tt.want)
+		}
+	}
+}
+
+func TestBignums(t *testing.T) {
+	testEval(t, []evalTest{
+		{src: "(* 99999999999 99999999999)", want: "9999999999800000000001"},
+		{src: "(+ 9223372036854775807 1)", want: "9223372036854775808"},
+		{src: "(- -9223372036854775808 1)", want: "-9223372036854775809"},
+		{src: "(* -1 -9223372036854775808)", want: "9223372036854775808"},
+		{src: "(- 9223372036854775808 1)", want: "9223372036854775807"},
+		{src: "(- (* 99999999999 99999999999) (* 99999999999 99999999999))", want: "0"},
+		{src: "(expt 2 100)", want: "1267650600228229401496703205376"},
+		{src: "(exact-integer? (+ 9223372036854775807 1))", want: "#t"},
+		{src: "(odd? 100000000000000000001)", want: "#t"},
+
+		// results that fit in an int are demoted, so eq? sees them as equal
+		{src: "(eq? (- (+ 9223372036854775807 1) 1) 9223372036854775807)", want: "#t"},
+	})
+
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := i.EvalString("(* 4294967296 4294967296)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := v.AsInt(); err == nil {
+		t.Errorf("AsInt(2^64) = %d, want an error", n)
+	}
+
+	if r, err := v.AsRat(); err != nil || r.String() != "18446744073709551616/1" {
+		t.Errorf("AsRat(2^64) = %s, %v, want 18446744073709551616/1", r, err)
+	}
+}
+
+func TestRationals(t *testing.T) {
+	testEval(t, []evalTest{
+		{src: "(/ 1 3)", want: "1/3"},
+		{src: "(/ 3)", want: "1/3"},
+		{src: "(/ 6 3)", want: "2"},
+		{src: "(/ 1 2 3)", want: "1/6"},
+		{src: "(+ 1/3 2/3)", want: "1"},
+		{src: "(* 1/3 3)", want: "1"},
+		{src: "(- 1/2 3/4)", want: "-1/4"},
+		{src: "(/ 1.0 0)", want: "+inf.0"},
+		{src: "(numerator 6/4)", want: "3"},
+		{src: "(denominator 6/4)", want: "2"},
+		{src: "(denominator 3)", want: "1"},
+		{src: "(numerator 0.5)", want: "1.0"},
+		{src: "(denominator 0.5)", want: "2.0"},
+		{src: "(rationalize 3/10 1/10)", want: "1/3"},
+		{src: "(rationalize 1/3 1/100)", want: "1/3"},
+		{src: "(rationalize .3 1/10)", want: "0.3333333333333333"},
+		{src: "(/ 1 0)", err: "1:1: division by zero"},
+	})
+}
+
+func TestExactness(t *testing.T) {
+	testEval(t, []evalTest{
+		{src: "(exact? 1/2)", want: "#t"},
+		{src: "(exact? 0.5)", want: "#f"},
+		{src: "(inexact? 0.5)", want: "#t"},
+		{src: "(exact-integer? 4)", want: "#t"},
+		{src: "(exact-integer? 4.0)", want: "#f"},
+		{src: "(exact-integer? 1/2)", want: "#f"},
+		{src: "(exact .25)", want: "1/4"},
+		{src: "(exact 1e20)", want: "100000000000000000000"},
+		{src: "(inexact 1/3)", want: "0.3333333333333333"},
+		{src: "(inexact 3)", want: "3.0"},
+		{src: "(nan? +nan.0)", want: "#t"},
+		{src: "(nan? 1)", want: "#f"},
+		{src: "(infinite? -inf.0)", want: "#t"},
+		{src: "(finite? 1.0)", want: "#t"},
+		{src: "(finite? +inf.0)", want: "#f"},
+		{src: "(exact +inf.0)", err: "1:1: +inf.0 has no exact representation"},
+		{src: `(exact "a")`, err: "1:1: type mismatch: expected num, got string"},
+
+		// inexact operands make the whole result inexact
+		{src: "(+ 1/2 0.5)", want: "1.0"},
+		{src: "(* 2 0.5)", want: "1.0"},
+		{src: "(- 3 1.0)", want: "2.0"},
+		{src: "(= 1 1.0)", want: "#t"},
+		{src: "(< 1/3 0.34)", want: "#t"},
+	})
+}
+
+func TestComplex(t *testing.T) {
+	testEval(t, []evalTest{
+		{src: "1+2i", want: "1.0+2.0i"},
+		{src: "-i", want: "0.0-1.0i"},
+		{src: "+inf.0i", want: "0.0+inf.0i"},
+		{src: "3-0i", want: "3.0"},
+		{src: "1@0", want: "1.0"},
+		{src: `(string->number "1+2i")`, want: "1.0+2.0i"},
+		{src: "#e1+2i", err: `1:1: bad number syntax: "#e1+2i"`},
+		{src: "1++2i", err: `1:1: bad number syntax: "1++2i"`},
+
+		{src: "(make-rectangular 1 2)", want: "1.0+2.0i"},
+		{src: "(make-rectangular 1 0)", want: "1"},
+		{src: "(make-polar 2 0)", want: "2"},
+		{src: "(real-part 1+2i)", want: "1.0"},
+		{src: "(imag-part 1+2i)", want: "2.0"},
+		{src: "(imag-part 3)", want: "0"},
+		{src: "(magnitude 3+4i)", want: "5.0"},
+		{src: "(magnitude -5)", want: "5"},
+		{src: "(angle -1)", want: "3.141592653589793"},
+		{src: "(angle 1)", want: "0"},
+
+		{src: "(* +i +i)", want: "-1.0"},
+		{src: "(+ 1+2i 1-2i)", want: "2.0"},
+		{src: "(/ 1+2i 2)", want: "0.5+1.0i"},
+		{src: "(= 1+2i 1+2i)", want: "#t"},
+		{src: "(zero? 0+0i)", want: "#t"},
+		{src: "(exact? 1+2i)", want: "#f"},
+		{src: "(inexact 1+2i)", want: "1.0+2.0i"},
+		{src: "(exact 1+2i)", err: "1:1: 1.0+2.0i has no exact representation"},
+		{src: "(< 1+2i 2)", err: "1:1: type mismatch: expected real, got complex"},
+
+		// real arguments outside a function's real domain give complex
+		// results, and exact arguments give exact results where possible
+		{src: "(sqrt -4)", want: "0.0+2.0i"},
+		{src: "(sqrt 16)", want: "4"},
+		{src: "(sqrt 1/4)", want: "1/2"},
+		{src: "(log -1)", want: "0.0+3.141592653589793i"},
+		{src: "(log 8 2)", want: "3.0"},
+		{src: "(asin 2)", want: "1.5707963267948966+1.3169578969248164i"},
+		{src: "(atan 1 1)", want: "0.7853981633974483"},
+		{src: "(exp 0)", want: "1.0"},
+		{src: "(expt 2 -2)", want: "1/4"},
+		{src: "(expt 2.0 0.5)", want: "1.4142135623730951"},
+		{src: "(expt 2 100000000000)", err: "1:1: result of expt too large"},
+	})
+
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := i.EvalString("1+2i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := v.AsRat(); err == nil {
+		t.Errorf("AsRat(1+2i) = %s, want an error", r)
+	}
+}
+
+func TestComparisons(t *testing.T) {
+	testEval(t, []evalTest{
+		{src: "(= 1 1 1)", want: "#t"},
+		{src: "(= 1 1 2)", want: "#f"},
+		{src: "(< 1 2 3)", want: "#t"},
+		{src: "(< 1 3 2)", want: "#f"},
+		{src: "(> 3 2 1)", want: "#t"},
+		{src: "(<= 1 1 2)", want: "#t"},
+		{src: "(>= 2 2 3)", want: "#f"},
+		{src: "(< 1)", want: "#t"},
+		{src: "(< 1/2 1 1.5 100000000000000000000)", want: "#t"},
+		{src: "(= 100000000000000000000 1e20)", want: "#t"},
+		{src: "(= +nan.0 +nan.0)", want: "#f"},
+		{src: "(=)", err: "1:1: =: argument length mismatch: expected at least 1, got 0"},
+		{src: "(< 1 'a)", err: "1:1: type mismatch: expected num, got identifier"},
+
+		{src: "(number? 1)", want: "#t"},
+		{src: "(number? 'a)", want: "#f"},
+		{src: "(zero? 0.0)", want: "#t"},
+		{src: "(zero? 1/2)", want: "#f"},
+		{src: "(positive? 1/2)", want: "#t"},
+		{src: "(positive? 0)", want: "#f"},
+		{src: "(negative? -1e-10)", want: "#t"},
+		{src: "(odd? 3)", want: "#t"},
+		{src: "(even? 0)", want: "#t"},
+		{src: "(even? 4.0)", want: "#t"},
+		{src: "(odd? 1.5)", err: "1:1: type mismatch: expected integer, got real"},
+		{src: "(positive? 1+2i)", err: "1:1: type mismatch: expected real, got complex"},
+
+		// an inexact argument makes the result of max and min inexact
+		{src: "(max 1 2 3)", want: "3"},
+		{src: "(max 1 2.0)", want: "2.0"},
+		{src: "(min 1 2.0)", want: "1.0"},
+		{src: "(max 1/2 1/3)", want: "1/2"},
+		{src: "(min 100000000000000000000 1)", want: "1"},
+		{src: "(max +nan.0 1)", want: "+nan.0"},
+		{src: "(max)", err: "1:1: max: argument length mismatch: expected at least 1, got 0"},
+	})
+
+	// comparisons are enough to end a loop
+	testEval(t, []evalTest{
+		{src: "(define (count n) (if (>= n 10) n (count (+ n 1)))) (count 0)", want: "10"},
+	}, WithLimits(Limits{MaxSteps: 1000}))
+}
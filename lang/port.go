@@ -1,15 +1,15 @@
 package lang
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"sync"
 )
 
 type port struct {
 	f        *os.File
-	r        *bufio.Reader
+	r        *reader
 	isInput  bool
 	isBinary bool
 	isOpen   bool
@@ -36,7 +36,7 @@ func openInputFile(args ...*object) (*object, error) {
 		return nil, fmt.Errorf("runtime error: %s", err.Error())
 	}
 
-	r := bufio.NewReader(f)
+	r := newReader(f.Name(), f)
 	p := &object{
 		t: portT,
 		v: &port{
@@ -74,16 +74,30 @@ func closePort(args ...*object) (*object, error) {
 	return nil, nil
 }
 
+var (
+	stdinOnce   sync.Once
+	stdinReader *reader
+)
+
+// stdin returns the reader shared by every read from standard input, so that
+// input buffered by one read is not lost to the next.
+func stdin() *reader {
+	stdinOnce.Do(func() {
+		stdinReader = newReader(os.Stdin.Name(), os.Stdin)
+	})
+
+	return stdinReader
+}
+
 func read(args ...*object) (*object, error) {
-	var (
-		r      *bufio.Reader
-		prompt bool
-	)
+	var r *reader
 
 	switch {
 	case len(args) == 0:
-		r = bufio.NewReader(os.Stdin)
-		prompt = true
+		r = stdin()
+		if _, err := io.WriteString(os.Stdout, "> "); err != nil {
+			return nil, err
+		}
 	case len(args) == 1:
 		o := args[0]
 		if !isPort(o) {
@@ -91,22 +105,14 @@ func read(args ...*object) (*object, error) {
 		}
 
 		r = o.v.(*port).r
-		prompt = false
 	default:
 		return nil, fmt.Errorf("too many arguments")
 	}
 
-	s, err := collectInput(r, "> ", prompt)
-	switch err {
-	case nil:
-		return parse(s)
-	case io.EOF:
-		if s == "" {
-			return eofObject()
-		}
-
-		return parse(s)
-	default:
-		return nil, err
+	o, err := r.read()
+	if err == io.EOF {
+		return eofObject()
 	}
+
+	return o, err
 }
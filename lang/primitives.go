@@ -2,24 +2,35 @@ package lang
 
 import (
 	"fmt"
-	"os"
+	"reflect"
+	"unicode/utf8"
 )
 
 /* PRIMITIVES */
 
 type primitiveFunc func(...*object) (*object, error)
 
+// primitiveProc is a procedure implemented in Go. It takes nArgs required
+// arguments followed by up to nOptional optional ones, and any number of
+// further arguments if hasTail is set.
 type primitiveProc struct {
-	f       primitiveFunc
-	nArgs   int
-	hasTail bool
+	name      string
+	f         primitiveFunc
+	nArgs     int
+	nOptional int
+	hasTail   bool
 }
 
 func procGen(f primitiveFunc, nArgs int, hasTail bool) *object {
+	return optProcGen(f, nArgs, 0, hasTail)
+}
+
+func optProcGen(f primitiveFunc, nArgs, nOptional int, hasTail bool) *object {
 	p := primitiveProc{
-		f:       f,
-		nArgs:   nArgs,
-		hasTail: hasTail,
+		f:         f,
+		nArgs:     nArgs,
+		nOptional: nOptional,
+		hasTail:   hasTail,
 	}
 
 	return &object{
@@ -28,6 +39,108 @@ func procGen(f primitiveFunc, nArgs int, hasTail bool) *object {
 	}
 }
 
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// reflectProcGen wraps an arbitrary Go function as the primitive name.
+// Arguments are converted to the function's parameter types, and a trailing
+// error result is returned as a Scheme error.
+func reflectProcGen(name string, fn interface{}) (*object, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return nil, fmt.Errorf("%s: cannot wrap %T as a procedure", name, fn)
+	}
+
+	ft := fv.Type()
+
+	nOut := ft.NumOut()
+	hasErr := nOut > 0 && ft.Out(nOut-1) == errorType
+	if hasErr {
+		nOut--
+	}
+
+	if nOut > 1 {
+		return nil, fmt.Errorf("%s: cannot wrap %s: too many results", name, ft)
+	}
+
+	nArgs := ft.NumIn()
+	if ft.IsVariadic() {
+		nArgs--
+	}
+
+	f := func(args ...*object) (ret *object, err error) {
+		in := make([]reflect.Value, len(args))
+		for i, a := range args {
+			var t reflect.Type
+			if ft.IsVariadic() && i >= nArgs {
+				t = ft.In(nArgs).Elem()
+			} else {
+				t = ft.In(i)
+			}
+
+			in[i], err = toGo(a, t)
+			if err != nil {
+				return nil, fmt.Errorf("%s: argument %d: %s", name, i+1, err)
+			}
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				ret, err = nil, fmt.Errorf("%s: %v", name, r)
+			}
+		}()
+
+		out := fv.Call(in)
+
+		if hasErr {
+			if e := out[len(out)-1]; !e.IsNil() {
+				return nil, e.Interface().(error)
+			}
+		}
+
+		if nOut == 0 {
+			return nil, nil
+		}
+
+		return fromGo(out[0])
+	}
+
+	return procGen(f, nArgs, ft.IsVariadic()), nil
+}
+
+func (p primitiveProc) checkArity(n int) error {
+	var err error
+
+	max := p.nArgs + p.nOptional
+
+	switch {
+	case p.nOptional == 0 && !p.hasTail && n != p.nArgs:
+		err = fmt.Errorf("argument length mismatch: %d != %d", p.nArgs, n)
+	case n < p.nArgs:
+		err = fmt.Errorf("argument length mismatch: expected at least %d, got %d", p.nArgs, n)
+	case !p.hasTail && n > max:
+		err = fmt.Errorf("argument length mismatch: expected at most %d, got %d", max, n)
+	}
+
+	if err != nil && p.name != "" {
+		err = fmt.Errorf("%s: %s", p.name, err)
+	}
+
+	return err
+}
+
+// nameProcs records each primitive's binding name for use in error messages.
+func nameProcs(m map[string]*object) {
+	for k, o := range m {
+		if !isPrimitive(o) {
+			continue
+		}
+
+		p := o.v.(primitiveProc)
+		p.name = k
+		o.v = p
+	}
+}
+
 func cons(o1, o2 *object) *object {
 
 	r := &object{
@@ -41,8 +154,14 @@ func cons(o1, o2 *object) *object {
 	return r
 }
 
-func consPrimitive(args ...*object) (*object, error) {
-	return cons(args[0], args[1]), nil
+func consGen(st *evalState) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		if err := st.alloc(1); err != nil {
+			return nil, err
+		}
+
+		return cons(args[0], args[1]), nil
+	}
 }
 
 func car(args ...*object) (*object, error) {
@@ -122,22 +241,58 @@ func cadr(args ...*object) (*object, error) {
 func eq(args ...*object) (*object, error) {
 	o1, o2 := args[0], args[1]
 
-	if o1.t != o2.t || o1.v != o2.v {
+	if o1 == o2 {
+		return boolObj(true), nil
+	}
+
+	if o1.t != o2.t {
 		return boolObj(false), nil
 	}
 
-	return boolObj(true), nil
+	// atoms are eq? when their values are; everything else, including
+	// procedures whose values cannot be compared, is only eq? to itself
+	switch o1.t {
+	case boolT, numT, charT, symbolT, strT, eofT:
+		return boolObj(o1.v == o2.v), nil
+	case listT:
+		return boolObj(o1.v == nil && o2.v == nil), nil
+	}
+
+	return boolObj(false), nil
 }
 
-func quit(args ...*object) (*object, error) {
-	os.Exit(0)
+// ExitError is returned when a program calls exit. It unwinds evaluation
+// back to the host, which decides whether to end the process.
+type ExitError struct {
+	Code int
+}
 
-	return nil, nil
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit %d", e.Code)
 }
 
-func write(args ...*object) (*object, error) {
-	fmt.Printf("%s\n", args[0])
-	return nil, nil
+func quit(args ...*object) (*object, error) {
+	code := 0
+
+	if len(args) == 1 {
+		o := args[0]
+		switch {
+		case isBool(o):
+			if !o.v.(bool) {
+				code = 1
+			}
+		case isNum(o) && o.v.(number).t == intT:
+			code = o.v.(number).intVal
+		case isNum(o) && o.v.(number).t == bigT:
+			return nil, fmt.Errorf("exit code %s out of range", o)
+		case isNum(o):
+			return nil, fmt.Errorf("exit code %s is not an exact integer", o)
+		default:
+			return nil, fmt.Errorf("exit code must be an exact integer or a boolean, got %s", o.t)
+		}
+	}
+
+	return nil, &ExitError{Code: code}
 }
 
 func evalProc(args ...*object) (*object, error) {
@@ -150,36 +305,38 @@ func evalProc(args ...*object) (*object, error) {
 
 	e := eObj.v.(*env)
 
+	if err := checkAcyclic(o); err != nil {
+		return nil, err
+	}
+
 	return eval(o, e)
 }
 
-func nullEnv(args ...*object) (*object, error) {
-	o := args[0]
+func nullEnvGen(st *evalState) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		o := args[0]
 
-	if !isNum(o) && o.v.(number).t != intT {
-		return nil, typeMismatch(numT, o.t)
-	}
+		if !isNum(o) || o.v.(number).t != intT {
+			return nil, typeMismatch(numT, o.t)
+		}
 
-	n := o.v.(number).intVal
+		n := o.v.(number).intVal
 
-	if n != 7 {
-		return nil, fmt.Errorf("null-environment supports only R7RS")
-	}
+		if n != 7 {
+			return nil, fmt.Errorf("null-environment supports only R7RS")
+		}
 
-	globalEnv := &env{
-		m:     globalEnvMap,
-		outer: nil,
-	}
+		ret := &object{
+			t: environmentT,
+			v: &env{
+				m:     map[string]*object{},
+				outer: restrictEnv(newGlobalEnv(st), st.allowed),
+				st:    st,
+			},
+		}
 
-	ret := &object{
-		t: environmentT,
-		v: &env{
-			m:     map[string]*object{},
-			outer: globalEnv,
-		},
+		return ret, nil
 	}
-
-	return ret, nil
 }
 
 func symbolToString(o ...*object) (*object, error) {
@@ -195,3 +352,58 @@ func symbolToString(o ...*object) (*object, error) {
 
 	return ret, nil
 }
+
+func charToInteger(o ...*object) (*object, error) {
+	c := o[0]
+	if !isChar(c) {
+		return nil, typeMismatch(charT, c.t)
+	}
+
+	return numObj(number{t: intT, intVal: int(c.v.(rune))}), nil
+}
+
+func integerToChar(o ...*object) (*object, error) {
+	n := o[0]
+	if !isNum(n) || n.v.(number).t != intT {
+		return nil, typeMismatch(intT, n.t)
+	}
+
+	i := n.v.(number).intVal
+	if i < 0 || i > utf8.MaxRune || !utf8.ValidRune(rune(i)) {
+		return nil, fmt.Errorf("integer %d is not a valid character", i)
+	}
+
+	ret := &object{
+		t: charT,
+		v: rune(i),
+	}
+
+	return ret, nil
+}
+
+func stringToNumber(o ...*object) (*object, error) {
+	s := o[0]
+	if !isString(s) {
+		return nil, typeMismatch(strT, s.t)
+	}
+
+	radix := 10
+	if len(o) == 2 {
+		r := o[1]
+		if !isNum(r) || r.v.(number).t != intT {
+			return nil, typeMismatch(intT, r.t)
+		}
+
+		radix = r.v.(number).intVal
+		if radix != 2 && radix != 8 && radix != 10 && radix != 16 {
+			return nil, fmt.Errorf("unsupported radix %d", radix)
+		}
+	}
+
+	n, ok := parseNumber(s.v.(string), radix)
+	if !ok {
+		return boolObj(false), nil
+	}
+
+	return numObj(n), nil
+}
@@ -0,0 +1,153 @@
+package lang
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* PRINTER */
+
+// printer writes the external representation of objects. Compound objects
+// in labels are written with datum labels: the first time as #n= followed by
+// the object, and after that as #n#. Unnumbered labels hold -1.
+type printer struct {
+	b      strings.Builder
+	labels map[*object]int
+	next   int
+}
+
+func isCompound(o *object) bool {
+	return (isList(o) && !isEmptyList(o)) || isVec(o)
+}
+
+// findLabels returns the compound objects within o that need datum labels.
+// These are the objects reachable from themselves or, if shared is set, all
+// objects reached more than once.
+func findLabels(o *object, shared bool) map[*object]int {
+	labels := map[*object]int{}
+	seen := map[*object]bool{}
+	active := map[*object]bool{}
+
+	var walk func(o *object)
+	walk = func(o *object) {
+		if !isCompound(o) {
+			return
+		}
+
+		if seen[o] {
+			if shared || active[o] {
+				labels[o] = -1
+			}
+			return
+		}
+
+		seen[o] = true
+		active[o] = true
+
+		if isVec(o) {
+			for _, x := range o.v.([]*object) {
+				walk(x)
+			}
+		} else {
+			l := o.v.(*list)
+			walk(l.car)
+			walk(l.cdr)
+		}
+
+		delete(active, o)
+	}
+
+	walk(o)
+
+	return labels
+}
+
+// writeString returns the external representation of o, with datum labels
+// for the objects in labels, which may be nil.
+func writeString(o *object, labels map[*object]int) string {
+	p := &printer{labels: labels}
+	p.write(o)
+
+	return p.b.String()
+}
+
+func (p *printer) write(o *object) {
+	if n, ok := p.labels[o]; ok {
+		if n >= 0 {
+			fmt.Fprintf(&p.b, "#%d#", n)
+			return
+		}
+
+		p.labels[o] = p.next
+		fmt.Fprintf(&p.b, "#%d=", p.next)
+		p.next++
+	}
+
+	switch {
+	case isVec(o):
+		p.b.WriteString("#(")
+		for i, x := range o.v.([]*object) {
+			if i > 0 {
+				p.b.WriteByte(' ')
+			}
+			p.write(x)
+		}
+		p.b.WriteByte(')')
+	case isCompound(o):
+		p.writeList(o.v.(*list))
+	default:
+		p.b.WriteString(o.String())
+	}
+}
+
+func (p *printer) writeList(l *list) {
+	p.b.WriteByte('(')
+	p.write(l.car)
+
+	for x := l.cdr; ; {
+		_, labeled := p.labels[x]
+
+		switch {
+		case isEmptyList(x):
+			p.b.WriteByte(')')
+			return
+		case isList(x) && !labeled:
+			l = x.v.(*list)
+			p.b.WriteByte(' ')
+			p.write(l.car)
+			x = l.cdr
+		default:
+			p.b.WriteString(" . ")
+			p.write(x)
+			p.b.WriteByte(')')
+			return
+		}
+	}
+}
+
+func writeGen(st *evalState) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		return nil, st.println(args[0].String())
+	}
+}
+
+// writeSharedGen returns write-shared, which writes its argument with datum
+// labels for all shared structure, not just cycles.
+func writeSharedGen(st *evalState) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		return nil, st.println(writeString(args[0], findLabels(args[0], true)))
+	}
+}
+
+// writeSimpleGen returns write-simple, which writes its argument without
+// datum labels. Cyclic arguments, which would never finish printing, are an
+// error.
+func writeSimpleGen(st *evalState) primitiveFunc {
+	return func(args ...*object) (*object, error) {
+		if len(findLabels(args[0], false)) > 0 {
+			return nil, fmt.Errorf("write-simple: cannot write cyclic data")
+		}
+
+		return nil, st.println(writeString(args[0], nil))
+	}
+}
@@ -0,0 +1,92 @@
+package lang
+
+import (
+	"bufio"
+	"io"
+)
+
+/* READER */
+
+// reader reads one complete datum at a time from an io.Reader. Tokens are
+// pulled from the lexer only until the current datum is closed, so a reader
+// can be used on interactive input.
+type reader struct {
+	l *lexer
+}
+
+// newReader returns a reader for r. Positions in errors and parsed objects
+// are reported against name.
+func newReader(name string, r io.Reader) *reader {
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+
+	return &reader{
+		l: newLexer(name, rr, lexStart),
+	}
+}
+
+func isPrefixToken(t int) bool {
+	return t == QUOTE || t == BACKTICK || t == COMMA || t == COMMAAT ||
+		t == LABELDEF
+}
+
+// readItems returns the tokens making up the next datum, or io.EOF if the
+// input ends before a datum begins. Whitespace and comments are dropped,
+// including data commented out with #;.
+func (r *reader) readItems() ([]item, error) {
+	var items []item
+
+	depth := 0
+
+	for {
+		it, ok := r.l.nextItem()
+		switch {
+		case !ok && len(items) == 0:
+			return nil, io.EOF
+		case !ok:
+			return nil, posErrorf(r.l.position(), "unexpected end of input")
+		case it.t == -1:
+			return nil, posErrorf(it.pos, "%s", it.input)
+		case it.t == WSPACE || it.t == COMMENT:
+			continue
+		case it.t == DATUMCOMMENT:
+			_, err := r.readItems()
+			if err == io.EOF {
+				return nil, posErrorf(r.l.position(), "unexpected end of input")
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		items = append(items, it)
+
+		switch it.t {
+		case LPAREN, LVEC, LU8VEC:
+			depth++
+		case RPAREN:
+			depth--
+			if depth < 0 {
+				return nil, posErrorf(it.pos, "unexpected )")
+			}
+		}
+
+		if depth == 0 && !isPrefixToken(it.t) {
+			return items, nil
+		}
+	}
+}
+
+// read returns the next datum, or io.EOF if there are none left.
+func (r *reader) read() (*object, error) {
+	items, err := r.readItems()
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(items)
+}
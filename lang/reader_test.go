@@ -0,0 +1,384 @@
+package lang
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// readAll reads every datum in src, returning their written forms.
+func readAll(src string) ([]string, error) {
+	r := newReader("", strings.NewReader(src))
+
+	var out []string
+	for {
+		o, err := r.read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+
+		out = append(out, o.String())
+	}
+}
+
+type readTest struct {
+	in   string
+	want string // the written form of the one datum in in
+	err  string // the error reading in should fail with instead
+}
+
+func testRead(t *testing.T, tests []readTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		got, err := readAll(tt.in)
+
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("read %q: error %v, want %q", tt.in, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("read %q: %s", tt.in, err)
+		case len(got) != 1 || got[0] != tt.want:
+			t.Errorf("read %q = %q, want [%q]", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	src := strings.NewReader("(1\n 2) (3")
+	r := newReader("", src)
+
+	o, err := r.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.String() != "(1 2)" {
+		t.Errorf("first datum = %s, want (1 2)", o)
+	}
+
+	// nothing past the end of the first datum has been read
+	if src.Len() != len(" (3") {
+		t.Errorf("read %d bytes too many", len(" (3")-src.Len())
+	}
+
+	if _, err := r.read(); err == nil || err.Error() != "2:7: unexpected end of input" {
+		t.Errorf("second datum: error %v, want unexpected end of input", err)
+	}
+
+	got, err := readAll("1 (2)\n\n'3 ")
+	if err != nil || strings.Join(got, " ") != "1 (2) (quote 3)" {
+		t.Errorf("read several data = %q, %v", got, err)
+	}
+
+	if got, err := readAll(" \n "); len(got) != 0 || err != nil {
+		t.Errorf("read blank input = %q, %v", got, err)
+	}
+}
+
+func TestReadLarge(t *testing.T) {
+	n := 1 << 20
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`"` + strings.Repeat("a", n) + `"`, `"` + strings.Repeat("a", n) + `"`},
+		{"#|" + strings.Repeat("x", n) + "|# 1", `1`},
+		{";" + strings.Repeat("x", n) + "\n2", `2`},
+		{strings.Repeat("(", n/16) + strings.Repeat(")", n/16), ""},
+	}
+
+	for _, tt := range tests {
+		got, err := readAll(tt.in)
+		if err != nil {
+			t.Errorf("read %d bytes: %s", len(tt.in), err)
+			continue
+		}
+
+		if len(got) != 1 || (tt.want != "" && got[0] != tt.want) {
+			t.Errorf("read %d bytes: wrong result", len(tt.in))
+		}
+	}
+}
+
+func TestReadComments(t *testing.T) {
+	testRead(t, []readTest{
+		{in: "; comment\n1", want: "1"},
+		{in: "1 ; comment", want: "1"},
+		{in: "#| block |# 2", want: "2"},
+		{in: "#| block #| nested |# still |# 2", want: "2"},
+		{in: "(1 #| inside |# 2)", want: "(1 2)"},
+		{in: "#;(1 2) 3", want: "3"},
+		{in: "#; #; 1 2 3", want: "3"},
+		{in: "(1 #;2 3)", want: "(1 3)"},
+		{in: "#| abc", err: "1:1: unterminated block comment"},
+		{in: "#;", err: "1:3: unexpected end of input"},
+	})
+}
+
+func TestReadPositions(t *testing.T) {
+	testRead(t, []readTest{
+		{in: "(1\n  2", err: "2:4: unexpected end of input"},
+		{in: "\n  )", err: "2:3: unexpected )"},
+		{in: "(a\n\t#q)", err: "2:2: bad # sequence"},
+		{in: "  1abc", err: "1:3: bad number syntax: \"1abc\""},
+	})
+
+	r := newReader("", strings.NewReader("\n  (a\n   (b))"))
+
+	o, err := r.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner := o.v.(*list).cdr.v.(*list).car
+	if o.pos == nil || o.pos.String() != "2:3" || inner.pos == nil || inner.pos.String() != "3:4" {
+		t.Errorf("positions of %s = %v and %v, want 2:3 and 3:4", o, o.pos, inner.pos)
+	}
+}
+
+func TestReadConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+
+		go func(n int) {
+			defer wg.Done()
+
+			want := fmt.Sprintf("(%d #(%d) \"%d\")", n, n, n)
+			for k := 0; k < 200; k++ {
+				got, err := readAll(want + " " + want)
+				if err != nil || len(got) != 2 || got[0] != want || got[1] != want {
+					t.Errorf("read %s = %q, %v", want, got, err)
+					return
+				}
+			}
+		}(n)
+	}
+
+	wg.Wait()
+
+	// readers that are interleaved keep their own parser state
+	r1 := newReader("", strings.NewReader("(a b) (c d)"))
+	r2 := newReader("", strings.NewReader("#(1 2) #(3 4)"))
+
+	var got []string
+	for _, r := range []*reader{r1, r2, r1, r2} {
+		o, err := r.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, o.String())
+	}
+
+	if want := "(a b) #(1 2) (c d) #(3 4)"; strings.Join(got, " ") != want {
+		t.Errorf("interleaved reads = %q, want %s", got, want)
+	}
+}
+
+func TestReadStrings(t *testing.T) {
+	testRead(t, []readTest{
+		{in: `"abc"`, want: `"abc"`},
+		{in: `""`, want: `""`},
+		{in: `"a\nb\tc"`, want: `"a\nb\tc"`},
+		{in: `"\x41;\x3bb;"`, want: `"Aλ"`},
+		{in: `"say \"hi\" \\ \a\b\r"`, want: `"say \"hi\" \\ \a\b\r"`},
+		{in: "\"one \\  \n   two\"", want: `"one two"`},
+		{in: "\"two\nlines\"", want: `"two\nlines"`},
+		{in: `"\x7;"`, want: `"\a"`},
+		{in: `"\x1b;"`, want: `"\x1b;"`},
+		{in: `"λ"`, want: `"λ"`},
+		{in: `"abc`, err: "1:2: unterminated quoted string"},
+		{in: `"\q"`, err: "1:2: unknown escape sequence \\q"},
+		{in: `"\x41"`, err: "1:2: unterminated hex escape"},
+		{in: `"\x110000;"`, err: "1:2: bad hex escape \\x110000;"},
+		{in: `"a\ b"`, err: "1:2: bad line continuation"},
+	})
+}
+
+func TestReadCharacters(t *testing.T) {
+	testRead(t, []readTest{
+		{in: `#\a`, want: `#\a`},
+		{in: `#\A`, want: `#\A`},
+		{in: `#\λ`, want: `#\λ`},
+		{in: `#\(`, want: `#\(`},
+		{in: `#\ `, want: `#\space`},
+		{in: `#\space`, want: `#\space`},
+		{in: `#\newline`, want: `#\newline`},
+		{in: `#\alarm`, want: `#\alarm`},
+		{in: `#\nul`, want: `#\null`},
+		{in: `#\x41`, want: `#\A`},
+		{in: `#\x`, want: `#\x`},
+		{in: `#\x0`, want: `#\null`},
+		{in: `#\x80`, want: `#\x80`},
+		{in: `(#\a #\b)`, want: `(#\a #\b)`},
+		{in: `#\foo`, err: "1:1: unknown character #\\foo"},
+		{in: `#\x110000`, err: "1:1: unknown character #\\x110000"},
+		{in: `#\`, err: "1:1: bad character"},
+	})
+
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for src, want := range map[string]string{
+		`(char->integer #\x3bb)`: "955",
+		`(integer->char 65)`:     `#\A`,
+		`(char? #\a)`:            "#t",
+		`(char? "a")`:            "#f",
+	} {
+		if v, err := i.EvalString(src); err != nil || v.String() != want {
+			t.Errorf("%s = %s, %v, want %s", src, v, err, want)
+		}
+	}
+}
+
+func TestReadIdentifiers(t *testing.T) {
+	testRead(t, []readTest{
+		{in: `abc`, want: `abc`},
+		{in: `ABC`, want: `ABC`},
+		{in: `λx`, want: `λx`},
+		{in: `|a b|`, want: `|a b|`},
+		{in: `|\x41;bc|`, want: `Abc`},
+		{in: `|a\|b|`, want: `|a\|b|`},
+		{in: `||`, want: `||`},
+		{in: `|1|`, want: `|1|`},
+		{in: `+`, want: `+`},
+		{in: `...`, want: `...`},
+		{in: `->x`, want: `->x`},
+		{in: `+.a`, want: `+.a`},
+		{in: "#!fold-case ABC", want: `abc`},
+		{in: "#!fold-case #\\SPACE", want: `#\space`},
+		{in: "#!fold-case #!no-fold-case ABC", want: `ABC`},
+		{in: "#!fold-case |ABC|", want: `ABC`},
+		{in: "#!/usr/bin/env scheme\n4", want: `4`},
+		{in: `|abc`, err: "1:1: unterminated |identifier|"},
+		{in: `1 #!foo`, err: "1:3: unknown directive #!foo"},
+	})
+}
+
+func TestReadLabels(t *testing.T) {
+	testRead(t, []readTest{
+		{in: `'#0=(a . #0#)`, want: `(quote #0=(a . #0#))`},
+		{in: `'#0=(a #0#)`, want: `(quote #0=(a #0#))`},
+		{in: `'(#0="x" #0#)`, want: `(quote ("x" "x"))`},
+		{in: `'#1=#(1 #1#)`, want: `(quote #0=#(1 #0#))`},
+		{in: `'(#0=(a) #1=(b . #1#) #0#)`, want: `(quote ((a) #0=(b . #0#) (a)))`},
+		{in: `'#0#`, err: "1:2: undefined datum label #0#"},
+		{in: `'(#0=1 #0=2)`, err: "1:8: duplicate datum label #0="},
+	})
+}
+
+func TestReadRoundTrip(t *testing.T) {
+	tests := []string{
+		`(1 2.5 -3/4 "s\n" #\x0 sym |two words| #t #f)`,
+		`#(1 #(2) () #u8(3))`,
+		`'#0=(1 2 . #0#)`,
+		`'(#0=(a) #0# #1=#(#1#))`,
+	}
+
+	for _, in := range tests {
+		first, err := readAll(in)
+		if err != nil {
+			t.Errorf("read %q: %s", in, err)
+			continue
+		}
+
+		second, err := readAll(strings.Join(first, " "))
+		if err != nil {
+			t.Errorf("read %q: %s", first, err)
+			continue
+		}
+
+		if strings.Join(first, " ") != strings.Join(second, " ") {
+			t.Errorf("round trip of %q: %q != %q", in, first, second)
+		}
+	}
+}
+
+func TestCyclicData(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"#0=(car . #0#)", "1:4: cyclic expression #0=(car . #0#)"},
+		{"(define x '#0=(1 . #0#)) (write-simple x)", "1:26: write-simple: cannot write cyclic data"},
+	}
+
+	for _, tt := range tests {
+		i, err := NewInterpreter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := i.EvalString(tt.src); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+
+	// cyclic data cannot be converted to Go values
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := i.DefineFunc("sum", func(xs []int) int { return len(xs) }); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{"'#0=(1 . #0#)", "'#0=(1 2 3 . #0#)", "'#0=(#0#)", "'#0=#(1 #0#)"} {
+		v, err := i.EvalString(src)
+		if err != nil {
+			t.Fatalf("%s: %s", src, err)
+		}
+
+		if _, err := v.ToGo(); err == nil {
+			t.Errorf("ToGo(%s) succeeded", src)
+		}
+	}
+
+	for _, src := range []string{"'#0=(1 . #0#)", "'#0=(1 2 3 . #0#)", "'(1 . #0=(2 . #0#))"} {
+		v, err := i.EvalString(src)
+		if err != nil {
+			t.Fatalf("%s: %s", src, err)
+		}
+
+		if _, err := v.AsList(); err == nil {
+			t.Errorf("AsList(%s) succeeded", src)
+		}
+
+		if _, err := i.EvalString("(sum " + src + ")"); err == nil {
+			t.Errorf("(sum %s) succeeded", src)
+		}
+	}
+}
+
+func TestReadLineEndings(t *testing.T) {
+	testRead(t, []readTest{
+		{in: "(define\r\n  x\r\n  1)\r\n", want: "(define x 1)"},
+		{in: "(a\fb\rc)", want: "(a b c)"},
+		{in: "; comment\r\n#\\a\r\n", want: `#\a`},
+		{in: "\"one \\\r\n  two\"", want: `"one two"`},
+		{in: "\"one \\  \r  two\"", want: `"one two"`},
+	})
+
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := "#!/usr/bin/env scheme\r\n(define (f x)\r\n  (car x))\r\n(f '(1 2))\r\n"
+	if v, err := i.EvalString(src); err != nil || v.String() != "1" {
+		t.Errorf("CRLF script = %s, %v, want 1", v, err)
+	}
+}
@@ -0,0 +1,168 @@
+package lang
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSandboxed(t *testing.T) {
+	i, err := NewInterpreter(Sandboxed())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"open-input-file", "close-port", "read", "eval", "exit", "quit", "include"} {
+		if _, err := i.EvalString(name); err == nil || !strings.Contains(err.Error(), "unknown identifier") {
+			t.Errorf("%s: error %v, want unknown identifier", name, err)
+		}
+	}
+
+	if v, err := i.EvalString("(let ((x '(1 2))) (map (lambda (y) (+ y 1)) x))"); err != nil || v.String() != "(2 3)" {
+		t.Errorf("sandboxed map = %s, %v, want (2 3)", v, err)
+	}
+}
+
+func TestWithPrimitives(t *testing.T) {
+	i, err := NewInterpreter(WithPrimitives("car", "cdr", "null-environment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := i.EvalString("(car (cdr '(1 2)))"); err != nil || v.String() != "2" {
+		t.Errorf("allowed primitives = %s, %v, want 2", v, err)
+	}
+
+	if _, err := i.EvalString("(cons 1 2)"); err == nil {
+		t.Errorf("cons is bound without being allowed")
+	}
+
+	// environments made by the script are restricted too
+	j, err := NewInterpreter(WithPrimitives("eval", "null-environment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := j.EvalString("(eval '(cons 1 2) (null-environment 7))"); err == nil {
+		t.Errorf("cons is bound in a null environment without being allowed")
+	}
+
+	// definitions are still possible
+	if v, err := i.EvalString("(define cons car) (cons '(3))"); err != nil || v.String() != "3" {
+		t.Errorf("redefined cons = %s, %v, want 3", v, err)
+	}
+}
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		src  string
+		code int
+	}{
+		{"(exit)", 0},
+		{"(exit 3)", 3},
+		{"(exit #t)", 0},
+		{"(exit #f)", 1},
+		{"(define (f) (exit 4) 5) (f)", 4},
+		{"(exit 1.5)", -1},
+		{"(exit 100000000000000000000)", -1},
+		{`(exit "a")`, -1},
+	}
+
+	for _, tt := range tests {
+		i, err := NewInterpreter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = i.EvalString(tt.src)
+
+		var exit *ExitError
+		switch {
+		case tt.code < 0 && (err == nil || errors.As(err, &exit)):
+			t.Errorf("%s: error %v, want a bad exit code", tt.src, err)
+		case tt.code >= 0 && (!errors.As(err, &exit) || exit.Code != tt.code):
+			t.Errorf("%s: error %v, want exit %d", tt.src, err, tt.code)
+		}
+	}
+
+	// exit stops the REPL, which returns it to the host
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var exit *ExitError
+	if err := i.REPL(strings.NewReader("(exit 2)\n(car 1)\n"), new(strings.Builder)); !errors.As(err, &exit) || exit.Code != 2 {
+		t.Errorf("REPL: error %v, want exit 2", err)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	var out strings.Builder
+
+	i, err := NewInterpreter(Sandboxed(), WithOutput(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := `(write "a") (write-shared '(#0=(1) #0#)) (write-simple '(x . y))`
+	if _, err := i.EvalString(src); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "\"a\"\n(#0=(1) #0#)\n(x . y)\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	// the REPL sends output to its writer
+	var repl strings.Builder
+	if err := i.REPL(strings.NewReader("(write 1)\n"), &repl); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "] 1\n] "; repl.String() != want {
+		t.Errorf("REPL output = %q, want %q", repl.String(), want)
+	}
+}
+
+func TestWithPrimitivesPrelude(t *testing.T) {
+	tests := []struct {
+		names []string
+		src   string
+		want  string
+	}{
+		{[]string{"car"}, "(car '(1))", "1"},
+		{[]string{"map", "+"}, "(map (lambda (x) (+ x 1)) '(1 2))", "(2 3)"},
+		{[]string{"let"}, "(let ((x 1) (y 2)) y)", "2"},
+		{[]string{"let", "let*"}, "(let* ((x 1) (y x)) y)", "1"},
+		{[]string{"and", "not"}, "(and 1 2)", "#t"},
+		{[]string{"or"}, "(or #f 2)", "#t"},
+		{[]string{"reverse", "list"}, "(reverse (list 1 2 3))", "(3 2 1)"},
+		{[]string{"cadr"}, "(cadr '(1 2))", "2"},
+	}
+
+	for _, tt := range tests {
+		i, err := NewInterpreter(WithPrimitives(tt.names...))
+		if err != nil {
+			t.Fatalf("%v: %s", tt.names, err)
+		}
+
+		if v, err := i.EvalString(tt.src); err != nil || v.String() != tt.want {
+			t.Errorf("%s with %v = %s, %v, want %s", tt.src, tt.names, v, err, tt.want)
+		}
+	}
+
+	// the transformer of a user macro also sees the bindings where it was
+	// defined rather than where it is used
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := `(define k 1)
+(define-syntax get-k (lambda () k))
+((lambda (k) (get-k)) 2)`
+	if v, err := i.EvalString(src); err != nil || v.String() != "1" {
+		t.Errorf("macro transformer scope = %s, %v, want 1", v, err)
+	}
+}
@@ -2,7 +2,6 @@
   package lang
 
   import (
-    "fmt"
     "strings"
   )
 
@@ -16,17 +15,21 @@
 %union {
   obj *object
   objs []*object
+  pos position
+  label string
 }
 
 %token <obj> NUM STRING IDENT BOOLEAN CHAR
-%token LPAREN RPAREN LVEC LU8VEC QUOTE BACKTICK COMMA COMMAAT DOT
-%token WSPACE
+%token <pos> LPAREN LVEC LU8VEC QUOTE BACKTICK COMMA COMMAAT
+%token <label> LABELDEF LABELREF
+%token RPAREN DOT
+%token WSPACE COMMENT DATUMCOMMENT
 %token <obj> IF LAMBDA DEFINE
 
 %type <obj> datum simple_datum compound_datum list vector expr quotation
-%type <obj> literal self_evaluating procedure
+%type <obj> literal self_evaluating procedure bytevector
 %type <obj> quasiquote qq_template list_qq_template unquote derived
-%type <obj> qq_template_or_splice splicing_unquotation
+%type <obj> qq_template_or_splice splicing_unquotation labeled_datum
 %type <objs> list_items exprs qq_templates_or_splices idents
 %type <obj> conditional lambda formals program definition def_formals datum_ident
 
@@ -37,11 +40,12 @@
 start:
   program
   {
-    root = $1
+    x := exprlex.(*exprLex)
+    x.root = x.resolve($1, map[*object]bool{})
   }
 |
   {
-    root = nil
+    exprlex.(*exprLex).root = nil
   }
 
 program:
@@ -51,13 +55,13 @@ program:
 definition:
   LPAREN DEFINE IDENT expr RPAREN
   {
-    $$ = cons(symbolObj("define"), cons($3, cons($4, emptyList)))
+    $$ = at($1, cons(symbolObj("define"), cons($3, cons($4, emptyList))))
   }
 | LPAREN DEFINE LPAREN def_formals RPAREN exprs RPAREN
   {
-    definition := $4
+    definition := at($3, $4)
     body := vecToList($6)
-    $$ = cons(symbolObj("define"), cons(definition, body))
+    $$ = at($1, cons(symbolObj("define"), cons(definition, body)))
   }
 
 def_formals:
@@ -81,22 +85,23 @@ expr:
     $$ = emptyList
   }
 | derived
+| labeled_datum
 
 conditional:
   LPAREN IF expr expr RPAREN
   {
-    $$ = cons(symbolObj("if"), cons($3, cons($4, emptyList)))
+    $$ = at($1, cons(symbolObj("if"), cons($3, cons($4, emptyList))))
   }
 | LPAREN IF expr expr expr RPAREN
   {
-    $$ = cons(symbolObj("if"), cons($3, cons($4, cons($5, emptyList))))
+    $$ = at($1, cons(symbolObj("if"), cons($3, cons($4, cons($5, emptyList)))))
   }
 
 lambda:
   LPAREN LAMBDA formals exprs RPAREN
   {
 	e := vecToList($4)
-    $$ = cons(symbolObj("lambda"), cons($3, e))
+    $$ = at($1, cons(symbolObj("lambda"), cons($3, e)))
   }
 
 formals:
@@ -106,13 +111,13 @@ formals:
   }
 | LPAREN idents RPAREN
   {
-    $$ = vecToList($2)
+    $$ = at($1, vecToList($2))
   }
 | IDENT
 | LPAREN idents DOT IDENT RPAREN
   {
     o := append($2, $4)
-    $$ = vecToImproperList(o)
+    $$ = at($1, vecToImproperList(o))
   }
 
 idents:
@@ -131,7 +136,7 @@ derived:
 procedure:
   LPAREN exprs RPAREN
   {
-	$$ = vecToList($2)
+	$$ = at($1, vecToList($2))
   }
 
 exprs:
@@ -151,7 +156,7 @@ literal:
 quasiquote:
   BACKTICK qq_template
   {
-    $$ = cons(symbolObj("quasiquote"), cons($2, emptyList))
+    $$ = at($1, cons(symbolObj("quasiquote"), cons($2, emptyList)))
   }
 
 qq_template:
@@ -181,7 +186,7 @@ list_qq_template:
   }
 |  LPAREN qq_templates_or_splices RPAREN
   {
-    $$ = vecToList($2)
+    $$ = at($1, vecToList($2))
   }
 | LPAREN qq_templates_or_splices DOT qq_template RPAREN
   {
@@ -189,35 +194,47 @@ list_qq_template:
     for i := len($2)-1; i >= 0; i-- {
       $$ = cons($2[i], $$)
     }
+    $$ = at($1, $$)
   }
 
 unquote:
   COMMA qq_template
   {
-    $$ = cons(symbolObj("unquote"), cons($2, emptyList))
+    $$ = at($1, cons(symbolObj("unquote"), cons($2, emptyList)))
   }
 
 splicing_unquotation:
   COMMAAT qq_template
   {
-    $$ = cons(symbolObj("unquote-splicing"), cons($2, emptyList))
+    $$ = at($1, cons(symbolObj("unquote-splicing"), cons($2, emptyList)))
   }
 
 self_evaluating:
   BOOLEAN
 | NUM
 | vector
+| bytevector
 | CHAR
 | STRING
 
 quotation:
   QUOTE datum
   {
-	$$ = cons(symbolObj("quote"), cons($2, emptyList))
+	$$ = at($1, cons(symbolObj("quote"), cons($2, emptyList)))
   }
 
 datum:
-  simple_datum | compound_datum | quotation
+  simple_datum | compound_datum | quotation | labeled_datum
+
+labeled_datum:
+  LABELDEF datum
+  {
+    $$ = exprlex.(*exprLex).define($1, $2)
+  }
+| LABELREF
+  {
+    $$ = exprlex.(*exprLex).ref($1, $<pos>1)
+  }
 
 simple_datum:
   NUM
@@ -225,6 +242,7 @@ simple_datum:
 | BOOLEAN
 | datum_ident
 | CHAR
+| bytevector
 
 datum_ident:
   IDENT
@@ -243,7 +261,7 @@ list:
   }
 | LPAREN list_items RPAREN
   {
-	$$ = vecToList($2)
+	$$ = at($1, vecToList($2))
   }
 | LPAREN list_items DOT datum RPAREN
   {
@@ -251,6 +269,7 @@ list:
     for i := len($2)-1; i >= 0; i-- {
       $$ = cons($2[i], $$)
     }
+    $$ = at($1, $$)
   }
 
 list_items:
@@ -266,46 +285,91 @@ list_items:
 vector:
   LVEC list_items RPAREN
   {
-    $$ = &object{
+    $$ = at($1, &object{
       t: vecT,
       v: $2,
+    })
+  }
+
+bytevector:
+  LU8VEC RPAREN
+  {
+    $$ = at($1, bytevectorObj([]byte{}))
+  }
+| LU8VEC list_items RPAREN
+  {
+    b, err := objsToBytes($2)
+    if err != nil {
+      exprlex.(*exprLex).err = posErrorf($1, "%s", err)
     }
+
+    $$ = at($1, bytevectorObj(b))
   }
 
 %%
 
 const EOF = 0
 
+// at records that o was read at pos. The shared empty list is left alone.
+func at(pos position, o *object) *object {
+  if o != emptyList {
+    o.pos = &pos
+  }
+
+  return o
+}
+
+// exprLex feeds the tokens of one datum to the parser and holds the result,
+// so that any number of parses can run at once.
 type exprLex struct {
-  lexer *lexer
+  items []item
+  last position
+
+  root *object
+  err error
+
+  // labels maps each datum label read so far to its datum, or to nil while
+  // the datum is being read. refs maps the placeholder left by each #n#
+  // reference to its label.
+  labels map[string]*object
+  refs map[*object]string
 }
 
 func (x *exprLex) Lex(yylval *exprSymType) int {
-  var (
-    item item
-    ok bool
-  )
+  var item item
 
-  for item, ok = <-x.lexer.items; item.t == WSPACE; item, ok = <-x.lexer.items {
-  }
+  for {
+    if len(x.items) == 0 {
+      return EOF
+    }
+
+    item, x.items = x.items[0], x.items[1:]
 
-  if !ok {
-    return 0
+    if item.t != WSPACE {
+      break
+    }
   }
 
+  x.last = item.pos
+  yylval.pos = item.pos
+
   switch item.t {
   case NUM:
 	n := parseNum(item.input)
     yylval.obj = &object{
       t: numT,
       v: n,
+      pos: &item.pos,
     }
 
     return NUM
   case STRING:
+    // the lexer has already checked the escapes
+    str, _ := unescape(item.input)
     yylval.obj = &object{
       t: strT,
-      v: item.input,
+      v: str,
+      pos: &item.pos,
     }
     
     return STRING
@@ -313,6 +377,7 @@ func (x *exprLex) Lex(yylval *exprSymType) int {
     yylval.obj = &object{
       t: symbolT,
       v: item.input,
+      pos: &item.pos,
     }
 
     return item.t
@@ -320,35 +385,99 @@ func (x *exprLex) Lex(yylval *exprSymType) int {
     yylval.obj = &object{
       t: boolT,
       v: strings.HasPrefix("#t", item.input),
+      pos: &item.pos,
     }
 
     return BOOLEAN
+  case LABELDEF, LABELREF:
+    yylval.label = strings.Trim(item.input, "#=")
+    _, ok := x.labels[yylval.label]
+
+    switch {
+    case item.t == LABELDEF && ok:
+      x.err = posErrorf(item.pos, "duplicate datum label %s", item.input)
+    case item.t == LABELDEF:
+      x.labels[yylval.label] = nil
+    case !ok:
+      x.err = posErrorf(item.pos, "undefined datum label %s", item.input)
+    }
+
+    return item.t
   case CHAR:
+    // the lexer has already checked the character
+    r, _ := decodeChar(item.input)
     yylval.obj = &object{
       t: charT,
-      v: item.input,
+      v: r,
+      pos: &item.pos,
     }
 
     return CHAR
   default:
     return item.t
   }
-
-  return EOF
 }
 
 func (x *exprLex) Error(e string) {
-  err = fmt.Errorf(e)
+  x.err = posErrorf(x.last, "%s", e)
+}
+
+// define sets label to o.
+func (x *exprLex) define(label string, o *object) *object {
+  if x.refs[o] == label {
+    x.err = posErrorf(x.last, "datum label #%s= refers to itself", label)
+  }
+
+  x.labels[label] = o
+
+  return o
 }
 
-var root *object
-var err error
+// ref returns a placeholder for label, which resolve later replaces.
+func (x *exprLex) ref(label string, pos position) *object {
+  o := at(pos, &object{t: symbolT, v: "#" + label + "#"})
+  x.refs[o] = label
+
+  return o
+}
 
-func parse(s string) (*object, error) {
-  root = nil
-  err = nil
+// resolve replaces the placeholders in o with the data they refer to. seen
+// guards against the cycles this creates.
+func (x *exprLex) resolve(o *object, seen map[*object]bool) *object {
+  if label, ok := x.refs[o]; ok {
+    return x.labels[label]
+  }
+
+  if len(x.refs) == 0 || seen[o] {
+    return o
+  }
+
+  seen[o] = true
+
+  switch {
+  case isVec(o):
+    objs := o.v.([]*object)
+    for i := range objs {
+      objs[i] = x.resolve(objs[i], seen)
+    }
+  case isList(o) && !isEmptyList(o):
+    l := o.v.(*list)
+    l.car = x.resolve(l.car, seen)
+    l.cdr = x.resolve(l.cdr, seen)
+  }
+
+  return o
+}
+
+// parse parses the tokens of a single datum read by a reader.
+func parse(items []item) (*object, error) {
+  x := &exprLex{
+    items: items,
+    labels: map[string]*object{},
+    refs: map[*object]string{},
+  }
 
-  exprParse(&exprLex{lexer: newLexer(s, lexStart)})
+  exprParse(x)
 
-  return root, err
+  return x.root, x.err
 }
@@ -0,0 +1,191 @@
+package lang
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/* LIMITS */
+
+// Limits bounds the resources a single evaluation may use. A zero field
+// means no limit.
+type Limits struct {
+	// MaxSteps is the number of expressions that may be evaluated,
+	// counting each iteration of a tail call.
+	MaxSteps int
+
+	// MaxDepth is how deeply evaluation may nest. Non-tail recursion
+	// consumes the Go stack, so this guards against stack overflow.
+	MaxDepth int
+
+	// MaxConses is the approximate number of cons cells that may be
+	// allocated.
+	MaxConses int
+
+	// MaxBytes is the number of bytes that may be allocated for
+	// bytevectors.
+	MaxBytes int
+}
+
+// DefaultLimits are the limits of an Interpreter created without
+// WithLimits. They only guard against overflowing the Go stack and running
+// out of memory in a single allocation.
+var DefaultLimits = Limits{
+	MaxDepth: 100000,
+	MaxBytes: 1 << 30,
+}
+
+var (
+	// ErrStepLimit is returned when an evaluation exceeds MaxSteps.
+	ErrStepLimit = errors.New("step limit exceeded")
+
+	// ErrDepthLimit is returned when an evaluation exceeds MaxDepth.
+	ErrDepthLimit = errors.New("recursion depth limit exceeded")
+
+	// ErrAllocLimit is returned when an evaluation exceeds MaxConses or
+	// MaxBytes.
+	ErrAllocLimit = errors.New("allocation limit exceeded")
+)
+
+/* EVALUATION STATE */
+
+// CanceledError is returned when evaluation stops because its context was
+// canceled or its deadline passed.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return "evaluation canceled: " + e.Err.Error()
+}
+
+// Unwrap returns the context error that stopped evaluation.
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// evalState is shared by every frame descending from one interpreter's global
+// environment and tracks the evaluation currently in progress.
+type evalState struct {
+	// ctxs holds the context of each evaluation in progress. There is more
+	// than one when a Go function called from Scheme evaluates more code.
+	ctxs   []context.Context
+	limits Limits
+
+	// allowed holds the names of the primitives that may be bound in a
+	// global environment, or nil if all of them may.
+	allowed map[string]bool
+
+	// out is where write and its variants print.
+	out io.Writer
+
+	steps  int
+	depth  int
+	conses int
+	bytes  int
+}
+
+// start begins an evaluation stopped by ctx. A top-level evaluation resets
+// the resource counters, while a nested one keeps counting against the
+// limits of the evaluation it is nested in and is also stopped by its
+// context.
+func (s *evalState) start(ctx context.Context) {
+	if len(s.ctxs) == 0 {
+		s.steps = 0
+		s.depth = 0
+		s.conses = 0
+		s.bytes = 0
+	}
+
+	s.ctxs = append(s.ctxs, ctx)
+}
+
+// finish ends the innermost evaluation in progress.
+func (s *evalState) finish() {
+	s.ctxs = s.ctxs[:len(s.ctxs)-1]
+}
+
+// step counts one evaluation step and returns an error if evaluation should
+// stop.
+func (s *evalState) step() error {
+	if s == nil {
+		return nil
+	}
+
+	s.steps++
+	if s.limits.MaxSteps > 0 && s.steps > s.limits.MaxSteps {
+		return ErrStepLimit
+	}
+
+	for _, ctx := range s.ctxs {
+		select {
+		case <-ctx.Done():
+			return &CanceledError{Err: ctx.Err()}
+		default:
+		}
+	}
+
+	return nil
+}
+
+// enter records one more level of nested evaluation. Every successful call
+// must be paired with a call to leave.
+func (s *evalState) enter() error {
+	if s == nil {
+		return nil
+	}
+
+	if s.limits.MaxDepth > 0 && s.depth >= s.limits.MaxDepth {
+		return ErrDepthLimit
+	}
+
+	s.depth++
+
+	return nil
+}
+
+func (s *evalState) leave() {
+	if s == nil {
+		return
+	}
+
+	s.depth--
+}
+
+// alloc records the allocation of n cons cells.
+func (s *evalState) alloc(n int) error {
+	if s == nil {
+		return nil
+	}
+
+	s.conses += n
+	if s.limits.MaxConses > 0 && s.conses > s.limits.MaxConses {
+		return ErrAllocLimit
+	}
+
+	return nil
+}
+
+// allocBytes records the allocation of n bytes. It is called before the
+// allocation, so that an oversized one is refused rather than attempted.
+func (s *evalState) allocBytes(n int) error {
+	if s == nil {
+		return nil
+	}
+
+	s.bytes += n
+	if s.limits.MaxBytes > 0 && (n > s.limits.MaxBytes || s.bytes > s.limits.MaxBytes) {
+		return ErrAllocLimit
+	}
+
+	return nil
+}
+
+// println writes text and a newline to the interpreter's output.
+func (s *evalState) println(text string) error {
+	_, err := fmt.Fprintln(s.out, text)
+
+	return err
+}
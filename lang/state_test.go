@@ -0,0 +1,37 @@
+package lang
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits Limits
+		src    string
+		want   error
+	}{
+		{Limits{MaxSteps: 1000}, "(define (f) (f)) (f)", ErrStepLimit},
+		{Limits{MaxDepth: 100}, "(define (f n) (+ 1 (f n))) (f 0)", ErrDepthLimit},
+		{Limits{MaxConses: 100}, "(define (f l) (f (cons 1 l))) (f '())", ErrAllocLimit},
+		{Limits{MaxBytes: 1 << 10}, "(make-bytevector 2048)", ErrAllocLimit},
+		{Limits{MaxBytes: 1 << 10}, "(bytevector-append (make-bytevector 600) (make-bytevector 600))", ErrAllocLimit},
+		{Limits{MaxBytes: 1 << 10}, "(make-bytevector 1000000000000)", ErrAllocLimit},
+		{Limits{MaxDepth: 100}, "(define-syntax loop (lambda () '(loop))) (loop)", ErrDepthLimit},
+		{DefaultLimits, "(define-syntax loop (lambda () '(loop))) (loop)", ErrDepthLimit},
+		{DefaultLimits, "(define-syntax loop (lambda () '(+ 1 (loop)))) (loop)", ErrDepthLimit},
+		{Limits{MaxSteps: 1000}, "(define (f n) (if (eq? n 0) 0 (f (- n 1)))) (f 10)", nil},
+		{Limits{MaxBytes: 1 << 10}, "(make-bytevector 1000) (make-bytevector 1000)", nil},
+	}
+
+	for _, tt := range tests {
+		i, err := NewInterpreter(WithLimits(tt.limits))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := i.EvalString(tt.src); !errors.Is(err, tt.want) {
+			t.Errorf("%s with %+v: error %v, want %v", tt.src, tt.limits, err, tt.want)
+		}
+	}
+}
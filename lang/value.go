@@ -0,0 +1,546 @@
+package lang
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+/* VALUE */
+
+// Value is a Scheme value. The zero Value is unspecified, such as the result
+// of a definition.
+type Value struct {
+	o *object
+}
+
+// String returns the external representation of v.
+func (v Value) String() string {
+	return v.o.String()
+}
+
+// Type returns the name of the Scheme type of v.
+func (v Value) Type() string {
+	if v.o == nil {
+		return "unspecified"
+	}
+
+	return v.o.t.String()
+}
+
+// IsUnspecified reports whether v is the zero Value.
+func (v Value) IsUnspecified() bool {
+	return v.o == nil
+}
+
+// IsTrue reports whether v counts as true in a conditional, which is
+// everything except #f and the zero Value.
+func (v Value) IsTrue() bool {
+	return v.o != nil && isTrue(v.o)
+}
+
+// AsInt returns the value of an integer.
+func (v Value) AsInt() (int, error) {
+	n, err := v.number()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.t {
+	case intT:
+		return n.intVal, nil
+	case bigT:
+		return 0, fmt.Errorf("integer %s out of range", n)
+	}
+
+	return 0, typeMismatch(intT, n.t)
+}
+
+// AsBigInt returns the value of an integer of any size.
+func (v Value) AsBigInt() (*big.Int, error) {
+	n, err := v.number()
+	if err != nil {
+		return nil, err
+	}
+
+	if n.t != intT && n.t != bigT {
+		return nil, typeMismatch(intT, n.t)
+	}
+
+	return new(big.Int).Set(n.big()), nil
+}
+
+// AsRat returns the value of an exact number.
+func (v Value) AsRat() (*big.Rat, error) {
+	n, err := v.number()
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.isExact() {
+		return nil, fmt.Errorf("%s is not exact", n)
+	}
+
+	return new(big.Rat).Set(n.rat()), nil
+}
+
+// AsFloat returns the value of a number as a float64.
+func (v Value) AsFloat() (float64, error) {
+	n, err := v.number()
+	if err != nil {
+		return 0, err
+	}
+
+	if n.t == complexT {
+		return 0, typeMismatch(realT, n.t)
+	}
+
+	return n.float(), nil
+}
+
+// AsComplex returns the value of a number as a complex128.
+func (v Value) AsComplex() (complex128, error) {
+	n, err := v.number()
+	if err != nil {
+		return 0, err
+	}
+
+	return n.complex(), nil
+}
+
+// AsString returns the contents of a string.
+func (v Value) AsString() (string, error) {
+	if !isString(v.o) {
+		return "", v.mismatch(strT)
+	}
+
+	return v.o.v.(string), nil
+}
+
+// AsSymbol returns the name of a symbol.
+func (v Value) AsSymbol() (string, error) {
+	if !isSymbol(v.o) {
+		return "", v.mismatch(symbolT)
+	}
+
+	return v.o.v.(string), nil
+}
+
+// AsList returns the elements of a proper list.
+func (v Value) AsList() ([]Value, error) {
+	if !isList(v.o) {
+		return nil, v.mismatch(listT)
+	}
+
+	var vals []Value
+
+	o, slow := v.o, v.o
+	for n := 1; !isEmptyList(o); n++ {
+		if !isList(o) {
+			return nil, fmt.Errorf("improper list %s", v)
+		}
+
+		l := o.v.(*list)
+		vals = append(vals, Value{o: l.car})
+		o = l.cdr
+
+		// slow follows at half the speed, so the two meet if the list is
+		// cyclic
+		if n%2 == 0 {
+			slow = slow.v.(*list).cdr
+			if slow == o {
+				return nil, fmt.Errorf("cyclic list %s", v)
+			}
+		}
+	}
+
+	return vals, nil
+}
+
+// AsVector returns the elements of a vector.
+func (v Value) AsVector() ([]Value, error) {
+	if !isVec(v.o) {
+		return nil, v.mismatch(vecT)
+	}
+
+	objs := v.o.v.([]*object)
+	vals := make([]Value, len(objs))
+	for i, o := range objs {
+		vals[i] = Value{o: o}
+	}
+
+	return vals, nil
+}
+
+func (v Value) number() (number, error) {
+	if !isNum(v.o) {
+		return number{}, v.mismatch(numT)
+	}
+
+	return v.o.v.(number), nil
+}
+
+func (v Value) mismatch(exp objType) error {
+	if v.o == nil {
+		return fmt.Errorf("type mismatch: expected %s, got unspecified", exp)
+	}
+
+	return typeMismatch(exp, v.o.t)
+}
+
+/* CONVERSION */
+
+// FromGo converts a Go value to a Scheme value. Booleans, numbers and
+// strings convert to their Scheme counterparts, byte slices to bytevectors,
+// other slices to lists, arrays to vectors and maps to association lists
+// sorted by key. A nil interface converts to the empty list and a Value or
+// Func is used as is.
+func FromGo(x interface{}) (Value, error) {
+	o, err := fromGo(reflect.ValueOf(x))
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{o: o}, nil
+}
+
+var (
+	valueType = reflect.TypeOf(Value{})
+	funcType  = reflect.TypeOf(Func(nil))
+	bytesType = reflect.TypeOf([]byte(nil))
+	bigType   = reflect.TypeOf((*big.Int)(nil))
+	ratType   = reflect.TypeOf((*big.Rat)(nil))
+)
+
+func fromGo(rv reflect.Value) (*object, error) {
+	if !rv.IsValid() {
+		return emptyList, nil
+	}
+
+	switch rv.Type() {
+	case valueType:
+		return rv.Interface().(Value).o, nil
+	case funcType:
+		f := rv.Interface().(Func)
+		return procGen(f.primitive(), 0, true), nil
+	case bytesType:
+		return bytevectorObj(append([]byte{}, rv.Bytes()...)), nil
+	case bigType:
+		if rv.IsNil() {
+			return emptyList, nil
+		}
+		return numObj(bigNum(new(big.Int).Set(rv.Interface().(*big.Int)))), nil
+	case ratType:
+		if rv.IsNil() {
+			return emptyList, nil
+		}
+		return numObj(exactNum(rv.Interface().(*big.Rat))), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return boolObj(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return numObj(number{t: intT, intVal: int(rv.Int())}), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return numObj(bigNum(new(big.Int).SetUint64(rv.Uint()))), nil
+	case reflect.Float32, reflect.Float64:
+		return numObj(number{t: realT, floatVal: rv.Float()}), nil
+	case reflect.Complex64, reflect.Complex128:
+		return numObj(complexNum(rv.Complex())), nil
+	case reflect.String:
+		return &object{
+			t: strT,
+			v: rv.String(),
+		}, nil
+	case reflect.Interface, reflect.Ptr:
+		if rv.IsNil() {
+			return emptyList, nil
+		}
+		return fromGo(rv.Elem())
+	case reflect.Slice, reflect.Array:
+		objs := make([]*object, rv.Len())
+		for i := range objs {
+			o, err := fromGo(rv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			objs[i] = o
+		}
+
+		if rv.Kind() == reflect.Array {
+			return &object{
+				t: vecT,
+				v: objs,
+			}, nil
+		}
+
+		return vecToList(objs), nil
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+
+		pairs := make([]*object, len(keys))
+		for i, k := range keys {
+			ko, err := fromGo(k)
+			if err != nil {
+				return nil, err
+			}
+
+			vo, err := fromGo(rv.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+
+			pairs[i] = cons(ko, vo)
+		}
+
+		return vecToList(pairs), nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a Scheme value", rv.Type())
+}
+
+// toGo converts o to a Go value of type t.
+func toGo(o *object, t reflect.Type) (reflect.Value, error) {
+	mismatch := func(exp objType) (reflect.Value, error) {
+		return reflect.Value{}, Value{o: o}.mismatch(exp)
+	}
+
+	rv := reflect.New(t).Elem()
+
+	if t == valueType {
+		rv.Set(reflect.ValueOf(Value{o: o}))
+		return rv, nil
+	}
+
+	if t == bytesType && isBytevector(o) {
+		rv.SetBytes(append([]byte{}, o.v.([]byte)...))
+		return rv, nil
+	}
+
+	if t == bigType {
+		b, err := Value{o: o}.AsBigInt()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.Set(reflect.ValueOf(b))
+		return rv, nil
+	}
+
+	if t == ratType {
+		r, err := Value{o: o}.AsRat()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.Set(reflect.ValueOf(r))
+		return rv, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if !isBool(o) {
+			return mismatch(boolT)
+		}
+		rv.SetBool(o.v.(bool))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := Value{o: o}.AsInt()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if rv.OverflowInt(int64(i)) {
+			return reflect.Value{}, fmt.Errorf("integer %d out of range for %s", i, t)
+		}
+		rv.SetInt(int64(i))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, err := Value{o: o}.AsInt()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if i < 0 || rv.OverflowUint(uint64(i)) {
+			return reflect.Value{}, fmt.Errorf("integer %d out of range for %s", i, t)
+		}
+		rv.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, err := Value{o: o}.AsFloat()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		c, err := Value{o: o}.AsComplex()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.SetComplex(c)
+	case reflect.String:
+		if !isString(o) && !isSymbol(o) {
+			return mismatch(strT)
+		}
+		rv.SetString(o.v.(string))
+	case reflect.Slice:
+		var (
+			vals []Value
+			err  error
+		)
+
+		if isVec(o) {
+			vals, err = Value{o: o}.AsVector()
+		} else {
+			vals, err = Value{o: o}.AsList()
+		}
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		rv.Set(reflect.MakeSlice(t, len(vals), len(vals)))
+		for i, val := range vals {
+			e, err := toGo(val.o, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			rv.Index(i).Set(e)
+		}
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", o.t, t)
+		}
+
+		x, err := Value{o: o}.ToGo()
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if x != nil {
+			rv.Set(reflect.ValueOf(x))
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", o.t, t)
+	}
+
+	return rv, nil
+}
+
+// ToGo converts v to a Go value: a bool, int, *big.Int, *big.Rat, float64,
+// complex128 or string for atoms, a []byte for bytevectors, a
+// []interface{} for proper lists and vectors and a [2]interface{} for a pair
+// whose cdr is not a list. An association list whose keys are distinct
+// strings or symbols, such as FromGo makes from a map with string keys,
+// converts to a map[string]interface{} from each key to the cdr of its entry.
+// Symbols and characters convert to strings and the zero Value converts to
+// nil. Cyclic data cannot be converted.
+func (v Value) ToGo() (interface{}, error) {
+	if len(findLabels(v.o, false)) > 0 {
+		return nil, fmt.Errorf("cannot convert cyclic data %s", v)
+	}
+
+	return v.goValue()
+}
+
+// goValue is ToGo for data known to be acyclic.
+func (v Value) goValue() (interface{}, error) {
+	o := v.o
+
+	switch {
+	case o == nil:
+		return nil, nil
+	case isBool(o):
+		return o.v.(bool), nil
+	case isNum(o):
+		n := o.v.(number)
+		switch n.t {
+		case intT:
+			return n.intVal, nil
+		case bigT:
+			return new(big.Int).Set(n.bigVal), nil
+		case ratT:
+			return new(big.Rat).Set(n.ratVal), nil
+		case complexT:
+			return n.complexVal, nil
+		}
+		return n.floatVal, nil
+	case isString(o), isSymbol(o):
+		return o.v.(string), nil
+	case isChar(o):
+		return string(o.v.(rune)), nil
+	case isBytevector(o):
+		return append([]byte{}, o.v.([]byte)...), nil
+	case isAlist(o):
+		m := map[string]interface{}{}
+		for ; !isEmptyList(o); o = o.v.(*list).cdr {
+			entry := o.v.(*list).car.v.(*list)
+
+			x, err := Value{o: entry.cdr}.goValue()
+			if err != nil {
+				return nil, err
+			}
+			m[entry.car.v.(string)] = x
+		}
+
+		return m, nil
+	case isList(o) && o.v != nil && !isList(o.v.(*list).cdr):
+		l := o.v.(*list)
+
+		car, err := Value{o: l.car}.goValue()
+		if err != nil {
+			return nil, err
+		}
+
+		cdr, err := Value{o: l.cdr}.goValue()
+		if err != nil {
+			return nil, err
+		}
+
+		return [2]interface{}{car, cdr}, nil
+	case isList(o), isVec(o):
+		var (
+			vals []Value
+			err  error
+		)
+
+		if isList(o) {
+			vals, err = v.AsList()
+		} else {
+			vals, err = v.AsVector()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		xs := make([]interface{}, len(vals))
+		for i, val := range vals {
+			x, err := val.goValue()
+			if err != nil {
+				return nil, err
+			}
+			xs[i] = x
+		}
+
+		return xs, nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a Go value", o.t)
+}
+
+// isAlist reports whether o is a non-empty list of pairs whose cars are
+// distinct strings or symbols.
+func isAlist(o *object) bool {
+	keys := map[string]bool{}
+
+	for ; isList(o) && !isEmptyList(o); o = o.v.(*list).cdr {
+		entry := o.v.(*list).car
+		if !isList(entry) || isEmptyList(entry) {
+			return false
+		}
+
+		k := entry.v.(*list).car
+		if !isString(k) && !isSymbol(k) || keys[k.v.(string)] {
+			return false
+		}
+		keys[k.v.(string)] = true
+	}
+
+	return len(keys) > 0 && isEmptyList(o)
+}
@@ -0,0 +1,196 @@
+package lang
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestValueAccessors(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eval := func(src string) Value {
+		t.Helper()
+
+		v, err := i.EvalString(src)
+		if err != nil {
+			t.Fatalf("%s: %s", src, err)
+		}
+
+		return v
+	}
+
+	if n, err := eval("(+ 1 2)").AsInt(); err != nil || n != 3 {
+		t.Errorf("AsInt = %d, %v, want 3", n, err)
+	}
+
+	if _, err := eval(`"3"`).AsInt(); err == nil {
+		t.Errorf("AsInt of a string succeeded")
+	}
+
+	if f, err := eval("1.5").AsFloat(); err != nil || f != 1.5 {
+		t.Errorf("AsFloat = %g, %v, want 1.5", f, err)
+	}
+
+	if f, err := eval("2").AsFloat(); err != nil || f != 2 {
+		t.Errorf("AsFloat of an integer = %g, %v, want 2", f, err)
+	}
+
+	if s, err := eval(`"abc"`).AsString(); err != nil || s != "abc" {
+		t.Errorf("AsString = %q, %v, want abc", s, err)
+	}
+
+	if s, err := eval(`'abc`).AsSymbol(); err != nil || s != "abc" {
+		t.Errorf("AsSymbol = %q, %v, want abc", s, err)
+	}
+
+	if l, err := eval(`'(1 "a" b)`).AsList(); err != nil || len(l) != 3 || l[1].String() != `"a"` {
+		t.Errorf("AsList = %v, %v, want 3 elements", l, err)
+	}
+
+	if _, err := eval(`'(1 . 2)`).AsList(); err == nil {
+		t.Errorf("AsList of an improper list succeeded")
+	}
+
+	if l, err := eval(`'#(1 2)`).AsVector(); err != nil || len(l) != 2 {
+		t.Errorf("AsVector = %v, %v, want 2 elements", l, err)
+	}
+
+	for src, want := range map[string]bool{"#f": false, "#t": true, "0": true, "'()": true} {
+		if got := eval(src).IsTrue(); got != want {
+			t.Errorf("IsTrue(%s) = %v, want %v", src, got, want)
+		}
+	}
+
+	v := eval("(define x 1)")
+	if !v.IsUnspecified() || v.IsTrue() || v.Type() != "unspecified" {
+		t.Errorf("result of define = %s of type %s, want unspecified", v, v.Type())
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		x    interface{}
+		want string
+	}{
+		{true, "#t"},
+		{42, "42"},
+		{uint8(7), "7"},
+		{uint64(1 << 63), "9223372036854775808"},
+		{1.5, "1.5"},
+		{"s", `"s"`},
+		{[]int{1, 2}, "(1 2)"},
+		{[]int{}, "()"},
+		{[2]string{"a", "b"}, `#("a" "b")`},
+		{[]byte{1, 2}, "#u8(1 2)"},
+		{map[string]int{"b": 2, "a": 1}, `(("a" . 1) ("b" . 2))`},
+		{[]interface{}{1, "a", nil, []int{2}}, `(1 "a" () (2))`},
+		{big.NewInt(5), "5"},
+		{nil, "()"},
+	}
+
+	for _, tt := range tests {
+		v, err := FromGo(tt.x)
+		if err != nil {
+			t.Errorf("FromGo(%#v): %s", tt.x, err)
+			continue
+		}
+
+		if v.String() != tt.want {
+			t.Errorf("FromGo(%#v) = %s, want %s", tt.x, v, tt.want)
+		}
+	}
+
+	if _, err := FromGo(make(chan int)); err == nil {
+		t.Errorf("FromGo of a channel succeeded")
+	}
+}
+
+func TestToGo(t *testing.T) {
+	i, err := NewInterpreter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"#t", true},
+		{"42", 42},
+		{"1.5", 1.5},
+		{`"s"`, "s"},
+		{"'sym", "sym"},
+		{`#\a`, "a"},
+		{"#u8(1 2)", []byte{1, 2}},
+		{`'(1 "a" (2))`, []interface{}{1, "a", []interface{}{2}}},
+		{`'#(1 #t)`, []interface{}{1, true}},
+		{"'(1 . 2)", [2]interface{}{1, 2}},
+		{`'(1 (2 . "x"))`, []interface{}{1, [2]interface{}{2, "x"}}},
+		{"'((a . 1) (b 2 3) (c))", map[string]interface{}{
+			"a": 1,
+			"b": []interface{}{2, 3},
+			"c": []interface{}{},
+		}},
+		{`'(("a" . 1) ("a" . 2))`, []interface{}{[2]interface{}{"a", 1}, [2]interface{}{"a", 2}}},
+		{"'((1 . 2))", []interface{}{[2]interface{}{1, 2}}},
+		{"(define x 1)", nil},
+	}
+
+	for _, tt := range tests {
+		v, err := i.EvalString(tt.src)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.src, err)
+		}
+
+		got, err := v.ToGo()
+		if err != nil {
+			t.Errorf("ToGo(%s): %s", tt.src, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ToGo(%s) = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+
+	// maps with string keys come back as maps whatever their values are
+	roundTrips := []struct {
+		m    interface{}
+		want map[string]interface{}
+	}{
+		{map[string]int{"a": 1, "b": 2}, map[string]interface{}{"a": 1, "b": 2}},
+		{map[string][]int{"a": {1, 2}, "b": nil}, map[string]interface{}{
+			"a": []interface{}{1, 2},
+			"b": []interface{}{},
+		}},
+		{map[string]interface{}{"a": "x", "b": map[string]bool{"c": true}}, map[string]interface{}{
+			"a": "x",
+			"b": map[string]interface{}{"c": true},
+		}},
+	}
+
+	for _, tt := range roundTrips {
+		v, err := FromGo(tt.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := v.ToGo()
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ToGo(FromGo(%v)) = %#v, %v, want %#v", tt.m, got, err, tt.want)
+		}
+	}
+
+	v, err := i.EvalString("car")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.ToGo(); err == nil {
+		t.Errorf("ToGo of a procedure succeeded")
+	}
+}